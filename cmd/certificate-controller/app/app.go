@@ -3,13 +3,24 @@ package app
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
 
 	"github.com/ericpuwang/certificate-controller/pkg/controller"
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/metrics"
 	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/server"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/cli/globalflag"
 	"k8s.io/component-base/logs"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/component-base/term"
 	"k8s.io/component-base/version/verflag"
 	"k8s.io/klog/v2"
@@ -44,15 +55,64 @@ func NewCertificateControllerCommand(ctx context.Context) *cobra.Command {
 				klog.Exit(err)
 			}
 
-			cs, err := controller.NewCertificateController(opt)
+			config, err := opt.ClientConfig()
 			if err != nil {
 				klog.Exit(err)
 			}
-			cs.Run(ctx)
+			client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "certificate-controller"))
+			if err != nil {
+				klog.Exit(err)
+			}
+
+			cs, err := controller.NewCertificateController(client, opt)
+			if err != nil {
+				klog.Exit(err)
+			}
+
+			metrics.Register()
+			srv := server.NewServer(opt.BindAddress)
+			srv.Handle("/metrics", legacyregistry.Handler())
+			srv.Handle("/ca/", cs.CAHandler())
+			if store := cs.Inventory(); store != nil {
+				// the records identify the requesters, unlike the other endpoints they are not public
+				srv.Handle("/inventory", server.WithDelegatedAuth(client, inventory.NewHandler(store)))
+			}
+			if crl := cs.CRLHandler(); crl != nil {
+				srv.Handle("/crl/", crl)
+			}
+			if ocsp := cs.OCSPHandler(); ocsp != nil {
+				srv.Handle("/ocsp", ocsp)
+				srv.HandlePrefix("/ocsp/", ocsp)
+			}
+			livez := cs.LivezChecks()
+			readyz := append([]server.HealthChecker{shutdownCheck(ctx)}, cs.ReadyzChecks()...)
+			srv.InstallHealthzHandler("/livez", livez...)
+			srv.InstallHealthzHandler("/readyz", readyz...)
+			srv.InstallHealthzHandler("/healthz", append(append([]server.HealthChecker{}, livez...), readyz...)...)
+			go func() {
+				if err := srv.Run(ctx); err != nil {
+					klog.ErrorS(err, "Http server exited")
+					klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+				}
+			}()
+
+			cs.Start(ctx)
+			if !opt.LeaderElection.LeaderElect {
+				cs.Run(ctx)
+				return
+			}
+			if err := runWithLeaderElection(ctx, config, opt, cs.Run); err != nil {
+				klog.Exit(err)
+			}
 		},
 	}
 
 	cmd.SetContext(ctx)
+	cmd.AddCommand(NewRevokeCommand(ctx))
+	cmd.AddCommand(NewInitCACommand(ctx))
+	cmd.AddCommand(NewSignCommand(ctx))
+	cmd.AddCommand(NewRequestCommand(ctx))
+	cmd.AddCommand(NewKMSPluginCommand(ctx))
 
 	fs := cmd.Flags()
 	namedFlagSets := opt.Flags()
@@ -66,3 +126,63 @@ func NewCertificateControllerCommand(ctx context.Context) *cobra.Command {
 	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
 	return cmd
 }
+
+// shutdownCheck fails once ctx is done so that no new traffic is routed to a terminating replica.
+func shutdownCheck(ctx context.Context) server.HealthChecker {
+	return server.NamedCheck("shutdown", func(_ *http.Request) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf("process is shutting down")
+		}
+		return nil
+	})
+}
+
+// runWithLeaderElection blocks until ctx is done, calling run only while this instance holds the lease.
+// The lease is released when ctx is cancelled so that a standby replica can take over immediately.
+func runWithLeaderElection(ctx context.Context, config *rest.Config, opt *options.CertificateControllerOptions, run func(ctx context.Context)) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to get hostname: %v", err)
+	}
+	// add a uniquifier so that two processes on the same host don't accidentally both become active
+	id := hostname + "_" + string(uuid.NewUUID())
+
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "leader-election"))
+	if err != nil {
+		return err
+	}
+	le := opt.LeaderElection
+	lock, err := resourcelock.New(le.ResourceLock, le.ResourceNamespace, le.ResourceName,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return fmt.Errorf("unable to create resource lock: %v", err)
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   le.LeaseDuration.Duration,
+		RenewDeadline:   le.RenewDeadline.Duration,
+		RetryPeriod:     le.RetryPeriod.Duration,
+		ReleaseOnCancel: true,
+		Name:            le.ResourceName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				select {
+				case <-ctx.Done():
+					klog.InfoS("Released leader election lease", "identity", id)
+				default:
+					klog.ErrorS(nil, "Leader election lost", "identity", id)
+					klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity == id {
+					return
+				}
+				klog.InfoS("New leader elected", "identity", identity)
+			},
+		},
+	})
+	return nil
+}
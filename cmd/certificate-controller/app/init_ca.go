@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/pki"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+)
+
+// NewInitCACommand generates the CA of a signer: a self-signed root CA written to files or a
+// kubernetes.io/tls Secret, or the key and certificate signing request of an intermediate CA.
+func NewInitCACommand(ctx context.Context) *cobra.Command {
+	opt := options.NewInitCAOptions()
+
+	cmd := &cobra.Command{
+		Use:          "init-ca --common-name=<name>",
+		Short:        "Generate a signing CA or the certificate signing request of an intermediate CA",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opt.Validate(); err != nil {
+				return err
+			}
+			return runInitCA(cmd.Context(), opt)
+		},
+	}
+
+	cmd.SetContext(ctx)
+
+	fs := cmd.Flags()
+	namedFlagSets := opt.Flags()
+	for _, f := range namedFlagSets.FlagSets {
+		fs.AddFlagSet(f)
+	}
+
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runInitCA(ctx context.Context, opt *options.InitCAOptions) error {
+	template, err := opt.Template()
+	if err != nil {
+		return err
+	}
+	key, err := pki.GenerateKey(opt.KeyAlgorithm, opt.KeySizeOrDefault())
+	if err != nil {
+		return err
+	}
+	keyPem, err := pki.MarshalPrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+
+	if opt.Intermediate {
+		csr, err := pki.NewCACertificateRequest(template, key)
+		if err != nil {
+			return err
+		}
+		csrPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+		if err := writeKeyAndFile(opt.KeyFile, keyPem, opt.CSRFile, csrPem); err != nil {
+			return err
+		}
+		fmt.Printf("wrote the key of intermediate CA %q to %s and its certificate signing request to %s\n", csr.Subject, opt.KeyFile, opt.CSRFile)
+		fmt.Printf("have the root CA issue the certificate, then append the chain up to the root to use it with --signing-cert-file\n")
+		return nil
+	}
+
+	ca, err := pki.NewSelfSignedCA(template, key)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	if len(opt.Secret) > 0 {
+		return createCASecret(ctx, opt, ca, certPem, keyPem)
+	}
+	if err := writeKeyAndFile(opt.KeyFile, keyPem, opt.CertFile, certPem); err != nil {
+		return err
+	}
+	fmt.Printf("wrote CA %q valid until %s to %s and its key to %s\n", ca.Subject, ca.NotAfter.UTC().Format(time.RFC3339), opt.CertFile, opt.KeyFile)
+	return nil
+}
+
+func createCASecret(ctx context.Context, opt *options.InitCAOptions, ca *x509.Certificate, certPem, keyPem []byte) error {
+	config, err := opt.ClientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "certificate-controller"))
+	if err != nil {
+		return err
+	}
+	namespace, name, _ := strings.Cut(opt.Secret, "/")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPem,
+			corev1.TLSPrivateKeyKey: keyPem,
+		},
+	}
+	// an existing CA is never replaced, rotating it is done by adding a new CA to the signer
+	if _, err := client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	fmt.Printf("created secret %s with CA %q valid until %s\n", opt.Secret, ca.Subject, ca.NotAfter.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// writeKeyAndFile writes a key and the certificate or certificate signing request of the key. The
+// key is removed if the second file cannot be written, so that a failed run can be retried without
+// leaving a key that nothing was issued for.
+func writeKeyAndFile(keyFile string, keyPem []byte, name string, data []byte) error {
+	if err := writeNewFile(keyFile, keyPem, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(name, data, 0644); err != nil {
+		if rmErr := os.Remove(keyFile); rmErr != nil {
+			return fmt.Errorf("%v, and unable to remove the key %s: %v", err, keyFile, rmErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeNewFile writes data to a file that must not exist, a CA or a key is never overwritten. The
+// file is removed if it cannot be written completely.
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+
+	"github.com/ericpuwang/certificate-controller/pkg/kmsplugin"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+)
+
+// NewKMSPluginCommand serves a local key file as a key service. It stands in for a key management
+// system to run the controller with --signing-kms-endpoint locally and is not meant for production.
+func NewKMSPluginCommand(ctx context.Context) *cobra.Command {
+	opt := options.NewKMSPluginOptions()
+
+	cmd := &cobra.Command{
+		Use:          "kms-plugin --key-file=<file>",
+		Short:        "Serve a local private key as a reference key service",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opt.Validate(); err != nil {
+				return err
+			}
+			return runKMSPlugin(cmd.Context(), opt)
+		},
+	}
+
+	cmd.SetContext(ctx)
+
+	fs := cmd.Flags()
+	namedFlagSets := opt.Flags()
+	for _, f := range namedFlagSets.FlagSets {
+		fs.AddFlagSet(f)
+	}
+
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runKMSPlugin(ctx context.Context, opt *options.KMSPluginOptions) error {
+	key, err := keyutil.PrivateKeyFromFile(opt.KeyFile)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("error reading key file %q: key did not implement crypto.Signer", opt.KeyFile)
+	}
+	return kmsplugin.NewServer(signer, opt.KeyID).Serve(ctx, opt.Endpoint)
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/pki"
+	"github.com/spf13/cobra"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+)
+
+// requestPollInterval is how often the request subcommand checks whether the certificate is issued.
+const requestPollInterval = 2 * time.Second
+
+// NewRequestCommand generates a key, submits its CertificateSigningRequest, optionally approves it
+// and waits for the certificate, which is written with the key to files or a kubernetes.io/tls Secret.
+func NewRequestCommand(ctx context.Context) *cobra.Command {
+	opt := options.NewRequestOptions()
+
+	cmd := &cobra.Command{
+		Use:          "request --dns-names=<name>",
+		Short:        "Generate a key and request its certificate with a CertificateSigningRequest",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opt.Validate(); err != nil {
+				return err
+			}
+			return runRequest(cmd.Context(), opt)
+		},
+	}
+
+	cmd.SetContext(ctx)
+
+	fs := cmd.Flags()
+	namedFlagSets := opt.Flags()
+	for _, f := range namedFlagSets.FlagSets {
+		fs.AddFlagSet(f)
+	}
+
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runRequest(ctx context.Context, opt *options.RequestOptions) error {
+	config, err := opt.ClientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "certificate-controller"))
+	if err != nil {
+		return err
+	}
+
+	key, err := pki.GenerateKey(opt.KeyAlgorithm, opt.KeySizeOrDefault())
+	if err != nil {
+		return err
+	}
+	keyPem, err := pki.MarshalPrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+	ips, err := opt.IPs()
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: opt.CommonName, Organization: opt.Organization},
+		DNSNames:    opt.DNSNames,
+		IPAddresses: ips,
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return err
+	}
+
+	// check the permission before the request is created, a request that cannot be approved is not left behind
+	if opt.Approve {
+		if err := checkApprove(ctx, client, opt.SignerName); err != nil {
+			return err
+		}
+	}
+
+	csr := &capi.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: opt.Name},
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			SignerName:        opt.SignerName,
+			Usages:            keyUsages(opt.Usages),
+			ExpirationSeconds: opt.ExpirationSeconds(),
+		},
+	}
+	if len(opt.Name) == 0 {
+		csr.GenerateName = path.Base(opt.SignerName) + "-"
+	}
+	if csr, err = client.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "created certificatesigningrequest %s for signer %s\n", csr.Name, opt.SignerName)
+
+	if opt.Approve {
+		if err := approve(ctx, client, csr); err != nil {
+			return err
+		}
+	}
+
+	chain, err := waitForCertificate(ctx, client, csr.Name, opt.Timeout)
+	if err != nil {
+		return err
+	}
+	if len(opt.Secret) > 0 {
+		return writeTLSSecret(ctx, client, opt.Secret, chain, keyPem)
+	}
+	if err := writeFileAtomic(opt.KeyFile, keyPem, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(opt.CertFile, chain, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote the certificate of certificatesigningrequest %s to %s and its key to %s\n", csr.Name, opt.CertFile, opt.KeyFile)
+	return nil
+}
+
+// checkApprove fails unless the requester may approve the requests of signerName.
+func checkApprove(ctx context.Context, client kubernetes.Interface, signerName string) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     "approve",
+				Group:    capi.GroupName,
+				Resource: "signers",
+				Name:     signerName,
+			},
+		},
+	}
+	review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("--approve: not allowed to approve the requests of signer %s, request without --approve to wait for another approver", signerName)
+	}
+	return nil
+}
+
+// approve approves csr on behalf of the requester, whose permission is checked by checkApprove.
+func approve(ctx context.Context, client kubernetes.Interface, csr *capi.CertificateSigningRequest) error {
+	now := metav1.Now()
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:               capi.CertificateApproved,
+		Status:             corev1.ConditionTrue,
+		Reason:             "SelfApproved",
+		Message:            "Approved by the requester with certificate-controller request",
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	})
+	if _, err := client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "approved certificatesigningrequest %s\n", csr.Name)
+	return nil
+}
+
+// waitForCertificate waits for the certificate of the csr named name to be issued and returns it,
+// or fails as soon as the request is denied or fails.
+func waitForCertificate(ctx context.Context, client kubernetes.Interface, name string, timeout time.Duration) ([]byte, error) {
+	var certificate []byte
+	err := wait.PollUntilContextTimeout(ctx, requestPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		csr, err := client.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, err
+			}
+			// transient errors are retried until the timeout
+			return false, nil
+		}
+		for _, c := range csr.Status.Conditions {
+			if (c.Type == capi.CertificateDenied || c.Type == capi.CertificateFailed) && c.Status == corev1.ConditionTrue {
+				return false, fmt.Errorf("certificatesigningrequest %s %s: %s: %s", name, strings.ToLower(string(c.Type)), c.Reason, c.Message)
+			}
+		}
+		if len(csr.Status.Certificate) == 0 {
+			return false, nil
+		}
+		certificate = csr.Status.Certificate
+		return true, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, fmt.Errorf("timed out after %v waiting for certificatesigningrequest %s to be issued, its key is discarded", timeout, name)
+	}
+	return certificate, err
+}
+
+// writeTLSSecret creates the kubernetes.io/tls Secret ref with the certificate and its key, or
+// replaces them in an existing one.
+func writeTLSSecret(ctx context.Context, client kubernetes.Interface, ref string, certPem, keyPem []byte) error {
+	namespace, name, _ := strings.Cut(ref, "/")
+	data := map[string][]byte{
+		corev1.TLSCertKey:       certPem,
+		corev1.TLSPrivateKeyKey: keyPem,
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		if _, err := client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "created secret %s\n", ref)
+		return nil
+	case err != nil:
+		return err
+	case secret.Type != corev1.SecretTypeTLS:
+		return fmt.Errorf("secret %s is of type %s, not %s", ref, secret.Type, corev1.SecretTypeTLS)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range data {
+		secret.Data[k] = v
+	}
+	if _, err := client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "updated secret %s\n", ref)
+	return nil
+}
+
+// writeFileAtomic writes data to name through a temporary file renamed in the same directory, so that
+// a certificate or a key is never left half-written. Unlike writeNewFile it replaces an existing
+// file, requesting a certificate again renews the same files.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
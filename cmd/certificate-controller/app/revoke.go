@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+)
+
+// NewRevokeCommand revokes a certificate of the inventory. The controller picks the revocation
+// up when it regenerates the certificate revocation list.
+func NewRevokeCommand(ctx context.Context) *cobra.Command {
+	opt := options.NewRevokeOptions()
+
+	cmd := &cobra.Command{
+		Use:          "revoke --serial=<serial>",
+		Short:        "Revoke a certificate recorded in the inventory",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opt.Validate(); err != nil {
+				return err
+			}
+			return runRevoke(cmd.Context(), opt)
+		},
+	}
+
+	cmd.SetContext(ctx)
+
+	fs := cmd.Flags()
+	namedFlagSets := opt.Flags()
+	for _, f := range namedFlagSets.FlagSets {
+		fs.AddFlagSet(f)
+	}
+
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runRevoke(ctx context.Context, opt *options.RevokeOptions) error {
+	serial, err := inventory.NormalizeSerial(opt.Serial)
+	if err != nil {
+		return err
+	}
+
+	var client kubernetes.Interface
+	if opt.Inventory.Backend != options.InventoryBackendFile {
+		config, err := opt.ClientConfig()
+		if err != nil {
+			return err
+		}
+		if client, err = kubernetes.NewForConfig(rest.AddUserAgent(config, "certificate-controller")); err != nil {
+			return err
+		}
+	}
+	store, err := inventory.NewStore(client, opt.Inventory)
+	if err != nil {
+		return err
+	}
+
+	record, revoked, err := inventory.Revoke(ctx, store, serial, opt.Reason, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		fmt.Printf("certificate %s of %s was already revoked at %s: %s\n", record.Serial, record.SignerName, record.RevokedAt.Format(time.RFC3339), record.RevocationReason)
+		return nil
+	}
+	fmt.Printf("certificate %s of %s revoked: %s\n", record.Serial, record.SignerName, record.RevocationReason)
+	return nil
+}
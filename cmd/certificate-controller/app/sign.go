@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/ericpuwang/certificate-controller/pkg/controller"
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	"github.com/spf13/cobra"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+)
+
+// NewSignCommand issues a certificate without the cluster, with the CA and the policy the controller
+// is configured with. It is meant for break-glass issuance while the apiserver is unavailable.
+func NewSignCommand(ctx context.Context) *cobra.Command {
+	opt := options.NewSignOptions()
+
+	cmd := &cobra.Command{
+		Use:          "sign --csr=<file>",
+		Short:        "Issue a certificate offline with the CA and the policy of a signer",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opt.Complete(); err != nil {
+				return err
+			}
+			if err := opt.Validate(); err != nil {
+				return err
+			}
+			return runSign(cmd.Context(), opt)
+		},
+	}
+
+	cmd.SetContext(ctx)
+
+	fs := cmd.Flags()
+	namedFlagSets := opt.Flags()
+	for _, f := range namedFlagSets.FlagSets {
+		fs.AddFlagSet(f)
+	}
+
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	flag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runSign(ctx context.Context, opt *options.SignOptions) error {
+	request, err := os.ReadFile(opt.CSRFile)
+	if err != nil {
+		return err
+	}
+
+	var client kubernetes.Interface
+	if opt.Inventory.Backend != options.InventoryBackendFile {
+		config, err := opt.ClientConfig()
+		if err != nil {
+			return err
+		}
+		if client, err = kubernetes.NewForConfig(rest.AddUserAgent(config, "certificate-controller")); err != nil {
+			return err
+		}
+	}
+	store, err := inventory.NewStore(client, opt.Inventory)
+	if err != nil {
+		return err
+	}
+	s, err := signer.New(opt.Signer, nil)
+	if err != nil {
+		return err
+	}
+
+	csr := &capi.CertificateSigningRequest{
+		Spec: capi.CertificateSigningRequestSpec{
+			Request:           request,
+			SignerName:        opt.SignerName,
+			Usages:            keyUsages(opt.Usages),
+			ExpirationSeconds: opt.ExpirationSeconds(),
+			Username:          offlineRequester(),
+		},
+	}
+	chain, err := controller.SignOffline(ctx, s, opt.Signer, store, csr, opt.SkipSANAuthorization)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	for _, certificate := range chain {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})...)
+	}
+	if len(opt.OutFile) == 0 {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	if err := os.WriteFile(opt.OutFile, out, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote the certificate issued by %s to %s\n", opt.SignerName, opt.OutFile)
+	return nil
+}
+
+func keyUsages(usages []string) []capi.KeyUsage {
+	keyUsages := make([]capi.KeyUsage, 0, len(usages))
+	for _, usage := range usages {
+		keyUsages = append(keyUsages, capi.KeyUsage(usage))
+	}
+	return keyUsages
+}
+
+// offlineRequester identifies who issued a certificate offline in the inventory, as offline:<user>@<host>.
+func offlineRequester() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("offline:%s@%s", name, host)
+}
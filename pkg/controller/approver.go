@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	capi "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	certificatelisters "k8s.io/client-go/listers/certificates/v1"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Reasons recorded on the conditions set by the approver.
+const (
+	reasonAutoApproved           = "AutoApproved"
+	reasonRequesterNotAuthorized = "RequesterNotAuthorized"
+)
+
+// approver approves the certificate signing requests of the configured signers whose requester
+// passes a SubjectAccessReview and whose request passes the signing policy, and denies the others.
+type approver struct {
+	client    kubernetes.Interface
+	csrLister certificatelisters.CertificateSigningRequestLister
+	queue     workqueue.RateLimitingInterface
+	configs   map[string]options.SignerConfiguration
+	sans      *sanAuthorizer
+
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+func newApprover(client kubernetes.Interface, csrLister certificatelisters.CertificateSigningRequestLister, configs map[string]options.SignerConfiguration, sans *sanAuthorizer, opts options.ApproverOptions) *approver {
+	a := &approver{
+		client:    client,
+		csrLister: csrLister,
+		sans:      sans,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "approver"),
+		configs:   map[string]options.SignerConfiguration{},
+		verb:      opts.Verb,
+		group:     opts.Group,
+	}
+	a.resource, a.subresource, _ = strings.Cut(opts.Resource, "/")
+	for _, name := range opts.SignerNames {
+		a.configs[name] = configs[name]
+	}
+	return a
+}
+
+func (a *approver) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer a.queue.ShutDown()
+
+	klog.InfoS("Starting certificate signing request approver", "verb", a.verb, "group", a.group, "resource", a.resource, "subresource", a.subresource)
+	defer klog.InfoS("Shutting down certificate signing request approver")
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, a.worker, time.Second)
+	}
+	<-ctx.Done()
+}
+
+func (a *approver) enqueue(key string) {
+	a.queue.Add(key)
+}
+
+func (a *approver) worker(ctx context.Context) {
+	for a.processNextItem(ctx) {
+	}
+}
+
+func (a *approver) processNextItem(ctx context.Context) bool {
+	key, quit := a.queue.Get()
+	if quit {
+		return false
+	}
+	defer a.queue.Done(key)
+
+	if err := a.sync(ctx, key.(string)); err != nil {
+		if errors.IsConflict(err) {
+			a.queue.AddAfter(key, time.Second)
+			return true
+		}
+		a.queue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("approve %v failed with : %v", key, err))
+		return true
+	}
+
+	a.queue.Forget(key)
+	return true
+}
+
+func (a *approver) sync(ctx context.Context, key string) error {
+	csr, err := a.csrLister.Get(key)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	config, ok := a.configs[csr.Spec.SignerName]
+	if !ok || len(csr.Status.Certificate) > 0 {
+		return nil
+	}
+	if hasTrueCondition(csr, capi.CertificateApproved) || hasTrueCondition(csr, capi.CertificateDenied) || hasTrueCondition(csr, capi.CertificateFailed) {
+		return nil
+	}
+
+	csr = csr.DeepCopy()
+	if _, reason, err := checkCertificateRequest(csr, config, a.sans); isUnresolvedSAN(err) {
+		// neither approve nor deny until the Service or Pod exists, retry with a backoff
+		return err
+	} else if err != nil {
+		return a.updateApproval(ctx, csr, capi.CertificateDenied, reason, err.Error())
+	}
+	allowed, err := a.authorize(ctx, csr)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		msg := fmt.Sprintf("requester %q is not allowed to %s %s named %q in API group %q", csr.Spec.Username, a.verb, a.resourceString(), csr.Spec.SignerName, a.group)
+		return a.updateApproval(ctx, csr, capi.CertificateDenied, reasonRequesterNotAuthorized, msg)
+	}
+	return a.updateApproval(ctx, csr, capi.CertificateApproved, reasonAutoApproved, "Auto approving certificate signing request of an authorized requester")
+}
+
+// authorize asks the apiserver whether the requester of csr may perform the configured verb on the
+// configured resource, named after the signer so that RBAC can grant access per signer.
+func (a *approver) authorize(ctx context.Context, csr *capi.CertificateSigningRequest) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(csr.Spec.Extra))
+	for k, v := range csr.Spec.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   csr.Spec.Username,
+			UID:    csr.Spec.UID,
+			Groups: csr.Spec.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        a.verb,
+				Group:       a.group,
+				Resource:    a.resource,
+				Subresource: a.subresource,
+				Name:        csr.Spec.SignerName,
+			},
+		},
+	}
+	sar, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return sar.Status.Allowed, nil
+}
+
+func (a *approver) updateApproval(ctx context.Context, csr *capi.CertificateSigningRequest, conditionType capi.RequestConditionType, reason, message string) error {
+	klog.InfoS("Recording approval decision", "csr", csr.Name, "decision", conditionType, "reason", reason, "message", message)
+	now := metav1.Now()
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:               conditionType,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	})
+	_, err := a.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+	return err
+}
+
+func (a *approver) resourceString() string {
+	resource := a.resource
+	if len(a.subresource) > 0 {
+		resource += "/" + a.subresource
+	}
+	return resource
+}
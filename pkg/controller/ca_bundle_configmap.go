@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// caBundleKey is the ConfigMap key holding the CA bundle, as in kube-root-ca.crt.
+	caBundleKey = "ca.crt"
+	// signerNameAnnotation records which signer a distributed ConfigMap belongs to.
+	signerNameAnnotation = "cms.io/signer-name"
+
+	// componentLabel tells the CA bundle ConfigMaps apart from the other ConfigMaps managed by the
+	// controller, such as the inventory shards and the certificate revocation lists.
+	componentLabel    = "app.kubernetes.io/component"
+	caBundleComponent = "ca-bundle"
+)
+
+// caBundleLabels are the labels of the CA bundle ConfigMaps.
+var caBundleLabels = labels.Set{managedByLabel: managedByValue, componentLabel: caBundleComponent}
+
+// caBundleConfigMap is a ConfigMap holding the CA bundle of a signer in the selected namespaces.
+type caBundleConfigMap struct {
+	signer   signer.Signer
+	name     string
+	selector labels.Selector
+}
+
+// caBundlePublisher writes the CA bundle ConfigMaps into the namespaces matching their selector,
+// restores them when they are edited or deleted, updates them when the CA changes and removes
+// them from namespaces that no longer match.
+type caBundlePublisher struct {
+	client          kubernetes.Interface
+	nsLister        corelisters.NamespaceLister
+	cmLister        corelisters.ConfigMapLister
+	informerFactory informers.SharedInformerFactory
+	hasSynced       []cache.InformerSynced
+	queue           workqueue.RateLimitingInterface
+	bundles         []caBundleConfigMap
+}
+
+func newCABundlePublisher(client kubernetes.Interface, informerFactory informers.SharedInformerFactory, resyncPeriod time.Duration, signers map[string]signer.Signer, configs map[string]options.SignerConfiguration) (*caBundlePublisher, error) {
+	// only watch the CA bundle ConfigMaps, a ConfigMap losing the labels is seen as deleted
+	cmInformerFactory := informers.NewSharedInformerFactoryWithOptions(client, resyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = caBundleLabels.String()
+		}))
+	nsInformer := informerFactory.Core().V1().Namespaces()
+	cmInformer := cmInformerFactory.Core().V1().ConfigMaps()
+	p := &caBundlePublisher{
+		client:          client,
+		nsLister:        nsInformer.Lister(),
+		cmLister:        cmInformer.Lister(),
+		informerFactory: cmInformerFactory,
+		hasSynced:       []cache.InformerSynced{nsInformer.Informer().HasSynced, cmInformer.Informer().HasSynced},
+		queue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ca_bundle_configmap"),
+	}
+	for name, s := range signers {
+		config := configs[name].CABundleConfigMap
+		if config == nil {
+			continue
+		}
+		selector, err := labels.Parse(config.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		p.bundles = append(p.bundles, caBundleConfigMap{signer: s, name: config.Name, selector: selector})
+		s.AddListener(p.enqueueAllNamespaces)
+	}
+
+	nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: p.enqueueNamespace,
+		UpdateFunc: func(_, newObj interface{}) {
+			p.enqueueNamespace(newObj)
+		},
+	})
+	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: p.enqueueConfigMap,
+		UpdateFunc: func(_, newObj interface{}) {
+			p.enqueueConfigMap(newObj)
+		},
+		DeleteFunc: p.enqueueConfigMap,
+	})
+	return p, nil
+}
+
+func (p *caBundlePublisher) enqueueNamespace(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+	p.queue.Add(ns.Name)
+}
+
+func (p *caBundlePublisher) enqueueConfigMap(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		if cm, ok = tombstone.Obj.(*corev1.ConfigMap); !ok {
+			return
+		}
+	}
+	p.queue.Add(cm.Namespace)
+}
+
+func (p *caBundlePublisher) enqueueAllNamespaces() {
+	namespaces, err := p.nsLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, ns := range namespaces {
+		p.queue.Add(ns.Name)
+	}
+}
+
+// Start starts the informer of the managed ConfigMaps.
+func (p *caBundlePublisher) Start(ctx context.Context) {
+	p.informerFactory.Start(ctx.Done())
+}
+
+func (p *caBundlePublisher) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer p.queue.ShutDown()
+
+	klog.InfoS("Starting CA bundle configmap publisher")
+	defer klog.InfoS("Shutting down CA bundle configmap publisher")
+
+	if !cache.WaitForNamedCacheSync("ca_bundle_configmap", ctx.Done(), p.hasSynced...) {
+		return
+	}
+	p.enqueueAllNamespaces()
+	for i := 0; i < 2; i++ {
+		go wait.UntilWithContext(ctx, p.worker, time.Second)
+	}
+	<-ctx.Done()
+}
+
+func (p *caBundlePublisher) worker(ctx context.Context) {
+	for p.processNextItem(ctx) {
+	}
+}
+
+func (p *caBundlePublisher) processNextItem(ctx context.Context) bool {
+	key, quit := p.queue.Get()
+	if quit {
+		return false
+	}
+	defer p.queue.Done(key)
+
+	if err := p.sync(ctx, key.(string)); err != nil {
+		p.queue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("publish CA bundles into namespace %v failed with : %v", key, err))
+		return true
+	}
+
+	p.queue.Forget(key)
+	return true
+}
+
+func (p *caBundlePublisher) sync(ctx context.Context, namespace string) error {
+	ns, err := p.nsLister.Get(namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Status.Phase == corev1.NamespaceTerminating {
+		return nil
+	}
+
+	var errs []error
+	for _, bundle := range p.bundles {
+		if bundle.selector.Matches(labels.Set(ns.Labels)) {
+			err = p.ensureConfigMap(ctx, namespace, bundle)
+		} else {
+			err = p.removeConfigMap(ctx, namespace, bundle)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+func (p *caBundlePublisher) ensureConfigMap(ctx context.Context, namespace string, bundle caBundleConfigMap) error {
+	data := map[string]string{caBundleKey: string(bundle.signer.TrustBundle())}
+
+	cm, err := p.cmLister.ConfigMaps(namespace).Get(bundle.name)
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        bundle.name,
+				Namespace:   namespace,
+				Labels:      labels.Merge(nil, caBundleLabels),
+				Annotations: map[string]string{signerNameAnnotation: bundle.signer.Name()},
+			},
+			Data: data,
+		}
+		_, err = p.client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		// the ConfigMap exists but lost the labels, take it over again
+		cm, err = p.client.CoreV1().ConfigMaps(namespace).Get(ctx, bundle.name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	if isCABundleConfigMapUpToDate(cm, bundle.signer.Name(), data) {
+		return nil
+	}
+
+	cm = cm.DeepCopy()
+	if cm.Labels == nil {
+		cm.Labels = map[string]string{}
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	for k, v := range caBundleLabels {
+		cm.Labels[k] = v
+	}
+	cm.Annotations[signerNameAnnotation] = bundle.signer.Name()
+	cm.Data = data
+	cm.BinaryData = nil
+	_, err = p.client.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (p *caBundlePublisher) removeConfigMap(ctx context.Context, namespace string, bundle caBundleConfigMap) error {
+	cm, err := p.cmLister.ConfigMaps(namespace).Get(bundle.name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Annotations[signerNameAnnotation] != bundle.signer.Name() {
+		return nil
+	}
+	err = p.client.CoreV1().ConfigMaps(namespace).Delete(ctx, bundle.name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &cm.UID},
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func isCABundleConfigMapUpToDate(cm *corev1.ConfigMap, signerName string, data map[string]string) bool {
+	if !caBundleLabels.AsSelector().Matches(labels.Set(cm.Labels)) || cm.Annotations[signerNameAnnotation] != signerName {
+		return false
+	}
+	if len(cm.BinaryData) > 0 || len(cm.Data) != len(data) {
+		return false
+	}
+	for k, v := range data {
+		if cm.Data[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"strings"
+
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+)
+
+// caCertificateHandler serves the CA certificates of every signer, the targets of the caIssuers
+// URLs: /ca/<signer name>/<CA fingerprint> serves any certificate of the CAs of the signer and of
+// their chains by SHA-256 fingerprint, /ca/<signer name> the CA issuing new certificates. A .pem
+// suffix serves the certificate in PEM instead of DER.
+type caCertificateHandler struct {
+	signers map[string]signer.Signer
+}
+
+func (h *caCertificateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/ca/")
+	path, isPEM := strings.CutSuffix(path, ".pem")
+	cert := h.certificate(path)
+	if cert == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if isPEM {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+		return
+	}
+	// RFC 5280 4.2.2.1: a single DER-encoded certificate is served as application/pkix-cert
+	w.Header().Set("Content-Type", "application/pkix-cert")
+	w.Write(cert.Raw)
+}
+
+// certificate returns the certificate at path, <signer name> or <signer name>/<CA fingerprint>.
+func (h *caCertificateHandler) certificate(path string) *x509.Certificate {
+	if s, ok := h.signers[path]; ok {
+		return s.Certificate()
+	}
+	// the signer name itself contains a slash
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return nil
+	}
+	s, ok := h.signers[path[:i]]
+	if !ok {
+		return nil
+	}
+	for _, cert := range s.CACertificates() {
+		if fingerprint(cert) == path[i+1:] {
+			return cert
+		}
+	}
+	return nil
+}
@@ -2,53 +2,130 @@ package controller
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/pem"
+	goerrors "errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"sort"
 	"time"
 
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/metrics"
+	"github.com/ericpuwang/certificate-controller/pkg/ocsp"
 	"github.com/ericpuwang/certificate-controller/pkg/options"
 	"github.com/ericpuwang/certificate-controller/pkg/signer"
 	capi "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	certificatelisters "k8s.io/client-go/listers/certificates/v1"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
-const signerName = "cms.io/app-serving"
-
 type CertificateController struct {
-	client      kubernetes.Interface
-	queue       workqueue.RateLimitingInterface
-	csrInformer cache.SharedIndexInformer
-	csrLister   certificatelisters.CertificateSigningRequestLister
-	signer      signer.CustomerSigner
+	client          kubernetes.Interface
+	queue           workqueue.RateLimitingInterface
+	informerFactory informers.SharedInformerFactory
+	csrInformer     cache.SharedIndexInformer
+	csrLister       certificatelisters.CertificateSigningRequestLister
+	signers         map[string]signer.Signer
+	configs         map[string]options.SignerConfiguration
+	workers         *workerMonitor
+	broadcaster     record.EventBroadcaster
+	recorder        record.EventRecorder
+	// approver is nil unless --auto-approve is set
+	approver *approver
+	// sans is nil unless a signer authorizes subjectAltNames
+	sans *sanAuthorizer
+	// trustBundles is nil unless --publish-cluster-trust-bundle is set
+	trustBundles *trustBundlePublisher
+	// caBundles is nil unless a signer distributes a CA bundle ConfigMap
+	caBundles *caBundlePublisher
+	// inventory is nil unless --inventory-backend is set
+	inventory inventory.Store
+	// crl is nil unless --crl is set
+	crl *crlPublisher
+	// ocsp is nil unless --ocsp is set
+	ocsp *ocsp.Responder
 }
 
-func NewCertificateController(opts *options.CertificateControllerOptions) (*CertificateController, error) {
+func NewCertificateController(client kubernetes.Interface, opts *options.CertificateControllerOptions) (*CertificateController, error) {
 	cc := &CertificateController{
-		queue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "certificate"),
+		client:  client,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "certificate"),
+		workers: newWorkerMonitor(opts.WorkerTimeout),
+		signers: map[string]signer.Signer{},
+		configs: map[string]options.SignerConfiguration{},
 	}
-	err := cc.setClient(opts.KubeConfig)
-	if err != nil {
+	cc.broadcaster = record.NewBroadcaster()
+	cc.recorder = cc.broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "certificate-controller"})
+	for _, config := range opts.Signers {
+		s, err := signer.New(config, client)
+		if err != nil {
+			return nil, err
+		}
+		cc.signers[s.Name()] = s
+		cc.configs[s.Name()] = config
+		if status, ok := s.(signer.CAStatus); ok {
+			metrics.RegisterSigningCA(s.Name(), status.NotAfter)
+			metrics.RegisterCARotation(s.Name(), status.RotationPhase)
+		}
+	}
+
+	var err error
+	if cc.inventory, err = inventory.NewStore(client, opts.Inventory); err != nil {
 		return nil, err
 	}
+	if opts.CRL.Enabled {
+		cc.crl = newCRLPublisher(cc.client, cc.inventory, cc.signers, opts.CRL)
+	}
+	if opts.OCSP.Enabled {
+		cc.ocsp = ocsp.NewResponder(cc.inventory, cc.signers, opts.OCSP)
+	}
 
 	factor := rand.Float64() + 1
 	resyncPeriod := time.Duration(12 * time.Hour * time.Duration(factor))
-	informerFactory := informers.NewSharedInformerFactory(cc.client, resyncPeriod)
-	certificateInfomer := informerFactory.Certificates().V1().CertificateSigningRequests()
+	cc.informerFactory = informers.NewSharedInformerFactory(cc.client, resyncPeriod)
+	certificateInfomer := cc.informerFactory.Certificates().V1().CertificateSigningRequests()
 	cc.csrInformer = certificateInfomer.Informer()
 	cc.csrLister = certificateInfomer.Lister()
+	for _, config := range opts.Signers {
+		if config.AuthorizeSubjectAltNames {
+			cc.sans, err = newSANAuthorizer(cc.informerFactory, opts.ClusterDomain, opts.SANResolutionTimeout)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if opts.Approver.Enabled {
+		cc.approver = newApprover(cc.client, cc.csrLister, cc.configs, cc.sans, opts.Approver)
+	}
+	if opts.PublishClusterTrustBundle {
+		cc.trustBundles = newTrustBundlePublisher(cc.client, cc.informerFactory, cc.signers)
+	}
+	for _, config := range opts.Signers {
+		if config.CABundleConfigMap != nil {
+			cc.caBundles, err = newCABundlePublisher(cc.client, cc.informerFactory, resyncPeriod, cc.signers, cc.configs)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
 	cc.csrInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			csr := obj.(*capi.CertificateSigningRequest)
@@ -81,21 +158,60 @@ func NewCertificateController(opts *options.CertificateControllerOptions) (*Cert
 	return cc, nil
 }
 
+// Start starts the informers and the CA reload loop. It does not write to the apiserver,
+// so it is run on every replica to keep standby instances warm and their readiness meaningful.
+func (cc *CertificateController) Start(ctx context.Context) {
+	for _, s := range cc.signers {
+		go s.Run(ctx)
+	}
+	cc.informerFactory.Start(ctx.Done())
+	if cc.caBundles != nil {
+		cc.caBundles.Start(ctx)
+	}
+	if cc.crl != nil {
+		cc.crl.Start(ctx)
+	}
+	if cc.ocsp != nil {
+		go cc.ocsp.Run(ctx)
+	}
+}
+
+// Run processes certificate signing requests until ctx is done. Start must have been called before.
 func (cc *CertificateController) Run(ctx context.Context) {
 	defer utilruntime.HandleCrash()
 	defer cc.queue.ShutDown()
 
-	klog.Info("Starting certificate controller", "name", signerName)
+	// only the replica processing certificate signing requests records events
+	cc.broadcaster.StartStructuredLogging(3)
+	cc.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cc.client.CoreV1().Events("")})
+	defer cc.broadcaster.Shutdown()
+
+	signerNames := make([]string, 0, len(cc.signers))
+	for name := range cc.signers {
+		signerNames = append(signerNames, name)
+	}
+	sort.Strings(signerNames)
+	klog.InfoS("Starting certificate controller", "signers", signerNames)
 	defer func() {
-		klog.Info("Shutting down certificate controller", "name", signerName)
+		klog.InfoS("Shutting down certificate controller", "signers", signerNames)
 	}()
 
-	go cc.csrInformer.Run(ctx.Done())
-
-	if !cache.WaitForNamedCacheSync(fmt.Sprintf("certificate-%s", signerName), ctx.Done(), cc.csrInformer.HasSynced) {
+	if !cache.WaitForNamedCacheSync("certificate", ctx.Done(), cc.cachesSynced()...) {
 		return
 	}
 
+	if cc.approver != nil {
+		go cc.approver.Run(ctx, 1)
+	}
+	if cc.trustBundles != nil {
+		go cc.trustBundles.Run(ctx)
+	}
+	if cc.caBundles != nil {
+		go cc.caBundles.Run(ctx)
+	}
+	if cc.crl != nil {
+		go cc.crl.Run(ctx)
+	}
 	for i := 0; i < 3; i++ {
 		go wait.UntilWithContext(ctx, cc.worker, time.Second)
 	}
@@ -113,6 +229,8 @@ func (cc *CertificateController) processNextItem(ctx context.Context) bool {
 		return false
 	}
 	defer cc.queue.Done(key)
+	cc.workers.start(key)
+	defer cc.workers.done(key)
 
 	if err := cc.sync(ctx, key.(string)); err != nil {
 		if errors.IsConflict(err) {
@@ -144,6 +262,16 @@ func (cc *CertificateController) sync(ctx context.Context, key string) error {
 	}
 
 	if len(csr.Status.Certificate) > 0 {
+		if _, ok := cc.signers[csr.Spec.SignerName]; !ok || cc.inventory == nil {
+			return nil
+		}
+		// the controller may have crashed or the inventory failed after the certificate was delivered
+		if err := cc.ensureRecorded(ctx, csr); err != nil {
+			return err
+		}
+		if _, ok := csr.Annotations[revokeAnnotation]; ok {
+			return cc.revoke(ctx, csr)
+		}
 		return nil
 	}
 
@@ -155,61 +283,244 @@ func (cc *CertificateController) handler(ctx context.Context, csr *capi.Certific
 	if !isCertificateRequestApproved(csr) || hasTrueCondition(csr, capi.CertificateFailed) {
 		return nil
 	}
-	if csr.Spec.SignerName != signerName {
+	s, ok := cc.signers[csr.Spec.SignerName]
+	if !ok {
 		return nil
 	}
-	certificateRequest, err := parseCSR(csr.Spec.Request)
+	certificateRequest, reason, err := checkCertificateRequest(csr, cc.configs[s.Name()], cc.sans)
+	if isUnresolvedSAN(err) {
+		// the Service or Pod may be created after the request, retry with a backoff until the
+		// resolution timeout, the event is only recorded by the first attempt
+		if cc.queue.NumRequeues(csr.Name) == 0 {
+			cc.recorder.Event(csr, corev1.EventTypeWarning, reason, err.Error())
+		}
+		return err
+	}
 	if err != nil {
-		klog.ErrorS(err, "Unable to parse csr %q", csr.Name)
+		return cc.markFailed(ctx, csr, reason, err)
+	}
+
+	signStart := time.Now()
+	chain, err := s.Sign(ctx, certificateRequest, csr.Spec.Usages, csr.Spec.ExpirationSeconds)
+	metrics.SignDuration.WithLabelValues(s.Name()).Observe(time.Since(signStart).Seconds())
+	// an expired CA is fixed by rotating it, keep retrying until then
+	if goerrors.Is(err, signer.ErrCAExpired) {
+		err := fmt.Errorf("signer %q: %v", s.Name(), err)
+		klog.ErrorS(err, "Unable to sign certificate signing request", "csr", csr.Name)
+		metrics.RejectedTotal.WithLabelValues(s.Name(), reasonCAExpired).Inc()
+		cc.recorder.Event(csr, corev1.EventTypeWarning, reasonCAExpired, err.Error())
 		return err
 	}
-	if err := certificateRequest.CheckSignature(); err != nil {
-		klog.ErrorS(err, "Unable to verify certificate request signature")
+	if err != nil {
+		klog.Error(err)
+		metrics.RejectedTotal.WithLabelValues(s.Name(), reasonSignerError).Inc()
+		cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonSignerError, "Unable to sign certificate: %v", err)
 		return err
 	}
-	if err := validateAppServingCSR(certificateRequest, csr.Spec.Usages); err != nil {
-		klog.ErrorS(err, "Invalid certificate signing request")
+	issued, err := x509.ParseCertificate(chain[0])
+	if err != nil {
 		return err
 	}
 
-	certificate, err := cc.signer.Sign(certificateRequest, csr.Spec.Usages, csr.Spec.ExpirationSeconds)
+	// the issued certificate followed by the intermediates, so clients only need to trust the root
+	csr.Status.Certificate = nil
+	for _, certificate := range chain {
+		csr.Status.Certificate = append(csr.Status.Certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})...)
+	}
+	_, err = cc.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
 	if err != nil {
-		klog.Error(err)
 		return err
 	}
+	metrics.SignedTotal.WithLabelValues(s.Name()).Inc()
+	cc.recorder.Eventf(csr, corev1.EventTypeNormal, reasonSigned, "Signed certificate with serial %s, valid until %s",
+		inventory.FormatSerial(issued.SerialNumber), issued.NotAfter.UTC().Format(time.RFC3339))
 
-	csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
-	_, err = cc.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	// only a delivered certificate is recorded, a failed status update signs again with another serial
+	if cc.inventory != nil {
+		return cc.recordIssued(ctx, s, csr, issued, chain)
+	}
+	return nil
+}
+
+// ensureRecorded records the certificate delivered to csr if it is missing from the inventory.
+// Expired certificates are not recorded again, nothing is left to revoke.
+func (cc *CertificateController) ensureRecorded(ctx context.Context, csr *capi.CertificateSigningRequest) error {
+	var chain [][]byte
+	for rest := csr.Status.Certificate; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		// reported by revoke, the certificate was not issued by the controller
+		return nil
+	}
+	issued, err := x509.ParseCertificate(chain[0])
+	if err != nil || !time.Now().Before(issued.NotAfter) {
+		return nil
+	}
+	_, err = cc.inventory.Get(ctx, inventory.FormatSerial(issued.SerialNumber))
+	if !goerrors.Is(err, inventory.ErrNotFound) {
+		return err
+	}
+	klog.InfoS("Recording delivered certificate missing from inventory", "csr", csr.Name, "serial", inventory.FormatSerial(issued.SerialNumber))
+	return cc.recordIssued(ctx, cc.signers[csr.Spec.SignerName], csr, issued, chain)
+}
+
+// recordIssued adds the delivered certificate to the inventory, retrying transient errors. A
+// certificate that cannot be recorded is reported by an event and a metric and the error is
+// returned, so that the csr is requeued and ensureRecorded records it later.
+func (cc *CertificateController) recordIssued(ctx context.Context, s signer.Signer, csr *capi.CertificateSigningRequest, issued *x509.Certificate, chain [][]byte) error {
+	ca, err := issuingCA(s, chain)
+	if err == nil {
+		record := inventory.NewRecord(csr, issued, ca)
+		err = retry.OnError(retry.DefaultBackoff, func(error) bool { return ctx.Err() == nil }, func() error {
+			return cc.inventory.Add(ctx, record)
+		})
+	}
 	if err != nil {
+		klog.ErrorS(err, "Unable to record issued certificate in inventory", "csr", csr.Name, "serial", inventory.FormatSerial(issued.SerialNumber))
+		metrics.InventoryErrorsTotal.WithLabelValues(s.Name()).Inc()
+		cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonInventoryError, "Unable to record certificate with serial %s in inventory, it cannot be revoked until it is recorded: %v", inventory.FormatSerial(issued.SerialNumber), err)
 		return err
 	}
 	return nil
 }
 
-func (cc *CertificateController) enqueueCertificateRequest(obj any) {
-	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+// issuingCA returns the CA that issued chain: the first intermediate of the chain, or the CA of
+// the trust bundle of the signer whose signature verifies, the current CA when it signs from a
+// root. A certificate recorded after the CA rotated was issued by a previous CA.
+func issuingCA(s signer.Signer, chain [][]byte) (*x509.Certificate, error) {
+	if len(chain) > 1 {
+		return x509.ParseCertificate(chain[1])
+	}
+	issued, err := x509.ParseCertificate(chain[0])
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf("couldn't get key from object %+v: %+v", obj, err))
-		return
+		return nil, err
 	}
-	cc.queue.Add(key)
+	if current := s.Certificate(); issued.CheckSignatureFrom(current) == nil {
+		return current, nil
+	}
+	cas, err := cert.ParseCertsPEM(s.TrustBundle())
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range cas {
+		if issued.CheckSignatureFrom(ca) == nil {
+			return ca, nil
+		}
+	}
+	return nil, fmt.Errorf("certificate with serial %s was not issued by a CA of signer %q", inventory.FormatSerial(issued.SerialNumber), s.Name())
 }
 
-func (cc *CertificateController) setClient(kubeconfig string) error {
-	var config *rest.Config
-	var err error
-	if kubeconfig == "" {
-		config, err = rest.InClusterConfig()
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+// revoke revokes the certificate issued for a csr annotated with revokeAnnotation. An invalid
+// annotation is reported by an event and not retried.
+func (cc *CertificateController) revoke(ctx context.Context, csr *capi.CertificateSigningRequest) error {
+	block, _ := pem.Decode(csr.Status.Certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		cc.recorder.Event(csr, corev1.EventTypeWarning, reasonRevocationFailed, "Unable to revoke certificate: status.certificate is not a PEM-encoded certificate")
+		return nil
 	}
+	issued, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		return err
+		cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonRevocationFailed, "Unable to revoke certificate: %v", err)
+		return nil
 	}
 
-	cc.client, err = kubernetes.NewForConfig(config)
+	reason := csr.Annotations[revokeAnnotation]
+	if _, ok := inventory.RevocationReasons[reason]; !ok && len(reason) > 0 {
+		cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonRevocationFailed, "Unable to revoke certificate: unknown revocation reason %q", reason)
+		return nil
+	}
+	record, revoked, err := inventory.Revoke(ctx, cc.inventory, inventory.FormatSerial(issued.SerialNumber), reason, time.Now())
+	if goerrors.Is(err, inventory.ErrNotFound) {
+		cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonRevocationFailed, "Unable to revoke certificate with serial %s: %v", inventory.FormatSerial(issued.SerialNumber), err)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if !revoked {
+		return nil
+	}
+	klog.InfoS("Revoked certificate", "csr", csr.Name, "serial", inventory.FormatSerial(issued.SerialNumber), "reason", record.RevocationReason)
+	cc.recorder.Eventf(csr, corev1.EventTypeNormal, reasonRevoked, "Revoked certificate with serial %s: %s", inventory.FormatSerial(issued.SerialNumber), record.RevocationReason)
+	if cc.crl != nil {
+		cc.crl.enqueue(csr.Spec.SignerName)
+	}
+	if cc.ocsp != nil {
+		cc.ocsp.Update(record)
+	}
 	return nil
 }
+
+// CRLHandler serves the certificate revocation lists at /crl/<signer name>, nil if --crl is not set.
+func (cc *CertificateController) CRLHandler() http.Handler {
+	if cc.crl == nil {
+		return nil
+	}
+	return cc.crl
+}
+
+// CAHandler serves the CA certificate of every signer at /ca/<signer name>.
+func (cc *CertificateController) CAHandler() http.Handler {
+	return &caCertificateHandler{signers: cc.signers}
+}
+
+// OCSPHandler serves the OCSP responder at /ocsp, nil if --ocsp is not set.
+func (cc *CertificateController) OCSPHandler() http.Handler {
+	if cc.ocsp == nil {
+		return nil
+	}
+	return cc.ocsp
+}
+
+// Inventory returns the store recording the issued certificates, nil if the inventory is disabled.
+func (cc *CertificateController) Inventory() inventory.Store {
+	return cc.inventory
+}
+
+// markFailed records a permanent failure on the csr as a Failed condition, so that the requester
+// learns why it will never be signed and the csr is not retried. Only an error updating the
+// status is returned, it is transient and the csr is requeued.
+func (cc *CertificateController) markFailed(ctx context.Context, csr *capi.CertificateSigningRequest, reason string, err error) error {
+	klog.ErrorS(err, "Refusing to sign certificate signing request", "csr", csr.Name, "reason", reason)
+	metrics.RejectedTotal.WithLabelValues(csr.Spec.SignerName, reason).Inc()
+	cc.recorder.Eventf(csr, corev1.EventTypeWarning, reasonPolicyViolation, "%s: %v", reason, err)
+
+	now := metav1.Now()
+	csr.Status.Conditions = append(csr.Status.Conditions, capi.CertificateSigningRequestCondition{
+		Type:               capi.CertificateFailed,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		Message:            err.Error(),
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	})
+	_, err = cc.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	return err
+}
+
+func (cc *CertificateController) cachesSynced() []cache.InformerSynced {
+	synced := []cache.InformerSynced{cc.csrInformer.HasSynced}
+	if cc.sans != nil {
+		synced = append(synced, cc.sans.hasSynced...)
+	}
+	return synced
+}
+
+func (cc *CertificateController) enqueueCertificateRequest(obj any) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key from object %+v: %+v", obj, err))
+		return
+	}
+	cc.queue.Add(key)
+	if cc.approver != nil {
+		cc.approver.enqueue(key)
+	}
+}
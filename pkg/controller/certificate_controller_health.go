@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/server"
+)
+
+// ReadyzChecks reports ready once the informer caches have synced
+// and the signing CA is loaded and valid, and the signers pass their checks, e.g. their CA has not expired.
+func (cc *CertificateController) ReadyzChecks() []server.HealthChecker {
+	return []server.HealthChecker{
+		server.NamedCheck("informer-sync", func(_ *http.Request) error {
+			for _, synced := range cc.cachesSynced() {
+				if !synced() {
+					return fmt.Errorf("informer caches not synced")
+				}
+			}
+			return nil
+		}),
+		server.NamedCheck("signing-ca", func(_ *http.Request) error {
+			now := time.Now()
+			for name, s := range cc.signers {
+				ca := s.Certificate()
+				if ca == nil {
+					return fmt.Errorf("signing CA of %q not loaded", name)
+				}
+				if now.Before(ca.NotBefore) {
+					return fmt.Errorf("signing CA of %q is not valid before %v", name, ca.NotBefore)
+				}
+			}
+			return nil
+		}),
+		server.NamedCheck("signers", func(r *http.Request) error {
+			for name, s := range cc.signers {
+				if err := s.Check(r.Context()); err != nil {
+					return fmt.Errorf("signing CA of %q: %v", name, err)
+				}
+			}
+			return nil
+		}),
+	}
+}
+
+// LivezChecks reports a failure when a worker has been stuck on a single item for longer than the worker timeout.
+func (cc *CertificateController) LivezChecks() []server.HealthChecker {
+	return []server.HealthChecker{
+		server.NamedCheck("workers", func(_ *http.Request) error {
+			return cc.workers.check(time.Now())
+		}),
+	}
+}
+
+// workerMonitor tracks the items currently being processed by the workers.
+type workerMonitor struct {
+	timeout time.Duration
+
+	lock     sync.Mutex
+	inflight map[interface{}]time.Time
+}
+
+func newWorkerMonitor(timeout time.Duration) *workerMonitor {
+	return &workerMonitor{
+		timeout:  timeout,
+		inflight: map[interface{}]time.Time{},
+	}
+}
+
+// start records that a worker picked up key, the workqueue never hands the same key to two workers at once.
+func (m *workerMonitor) start(key interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inflight[key] = time.Now()
+}
+
+func (m *workerMonitor) done(key interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.inflight, key)
+}
+
+func (m *workerMonitor) check(now time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, started := range m.inflight {
+		if elapsed := now.Sub(started); elapsed > m.timeout {
+			return fmt.Errorf("worker has been processing %v for %v", key, elapsed.Round(time.Second))
+		}
+	}
+	return nil
+}
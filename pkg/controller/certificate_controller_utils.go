@@ -5,15 +5,38 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/ericpuwang/certificate-controller/pkg/options"
 	capi "k8s.io/api/certificates/v1"
 )
 
-var appServingKeyUsages = []capi.KeyUsage{
-	capi.UsageDigitalSignature,
-	capi.UsageKeyEncipherment,
-	capi.UsageServerAuth,
-}
+// Reasons a certificate signing request could not be signed. Except for reasonSignerError and
+// reasonCAExpired they are permanent and recorded as the reason of the Failed condition.
+// reasonInventoryError reports a delivered certificate that could not be recorded.
+const (
+	reasonInvalidRequest   = "InvalidRequest"
+	reasonInvalidSignature = "InvalidSignature"
+	reasonPolicyViolation  = "PolicyViolation"
+	reasonSANNotAuthorized = "SubjectAltNameNotAuthorized"
+	reasonSANNotResolved   = "SubjectAltNameNotResolved"
+	reasonSignerError      = "SignerError"
+	reasonCAExpired        = "CAExpired"
+	reasonInventoryError   = "InventoryError"
+)
+
+// reasonSigned is the reason of the event recorded when a certificate is issued.
+const reasonSigned = "Signed"
+
+// Reasons of the events recorded when revoking the certificate of a csr annotated with revokeAnnotation.
+const (
+	reasonRevoked          = "Revoked"
+	reasonRevocationFailed = "RevocationFailed"
+)
+
+// revokeAnnotation revokes the certificate issued for a csr, its value is the revocation reason as
+// named by RFC 5280, e.g. keyCompromise. An empty value revokes for the unspecified reason.
+const revokeAnnotation = "cms.io/revoke"
 
 // parseCSR extracts the CSR from the bytes and decodes it.
 func parseCSR(pemBytes []byte) (*x509.CertificateRequest, error) {
@@ -28,19 +51,47 @@ func parseCSR(pemBytes []byte) (*x509.CertificateRequest, error) {
 	return csr, nil
 }
 
-func validateAppServingCSR(req *x509.CertificateRequest, usages []capi.KeyUsage) error {
-	// 必须包含server auth
-	if !container[capi.KeyUsage](capi.UsageServerAuth, usages) {
-		return fmt.Errorf("permitted key usages - must include ['server auth']")
+// checkCertificateRequest parses the request of csr, verifies its signature and validates it against
+// the signer configuration. A returned error is permanent unless isUnresolvedSAN reports it may be resolved
+// later, reason is the matching machine-readable reason. sans must not be nil if the signer authorizes
+// subjectAltNames.
+func checkCertificateRequest(csr *capi.CertificateSigningRequest, config options.SignerConfiguration, sans *sanAuthorizer) (*x509.CertificateRequest, string, error) {
+	certificateRequest, err := parseCSR(csr.Spec.Request)
+	if err != nil {
+		return nil, reasonInvalidRequest, fmt.Errorf("unable to parse csr: %v", err)
+	}
+	if err := certificateRequest.CheckSignature(); err != nil {
+		return nil, reasonInvalidSignature, fmt.Errorf("unable to verify certificate request signature: %v", err)
+	}
+	if err := validateCSR(certificateRequest, csr.Spec.Usages, config); err != nil {
+		return nil, reasonPolicyViolation, err
+	}
+	if config.AuthorizeSubjectAltNames {
+		if err := sans.authorize(csr, certificateRequest); isUnresolvedSAN(err) {
+			return nil, reasonSANNotResolved, err
+		} else if err != nil {
+			return nil, reasonSANNotAuthorized, err
+		}
+	}
+	return certificateRequest, "", nil
+}
+
+// validateCSR checks the requested usages against the signer configuration and that the request
+// only carries subjectAltNames the signer copies into the issued certificate.
+func validateCSR(req *x509.CertificateRequest, usages []capi.KeyUsage, config options.SignerConfiguration) error {
+	for _, usage := range config.RequiredUsages {
+		if !container[capi.KeyUsage](usage, usages) {
+			return fmt.Errorf("permitted key usages - must include %s", formatUsages(config.RequiredUsages))
+		}
 	}
-	// 不能包含"digital signature", "key encipherment", "server auth"之外的键
 	for _, usage := range usages {
-		if !container[capi.KeyUsage](usage, appServingKeyUsages) {
-			return fmt.Errorf("permitted key usages - must not include key usages beyond ['digital signature', 'key encipherment', 'server auth']")
+		if !container[capi.KeyUsage](usage, config.AllowedUsages) {
+			return fmt.Errorf("permitted key usages - must not include key usages beyond %s", formatUsages(config.AllowedUsages))
 		}
 	}
 
-	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+	// a serving certificate is useless without a name to serve
+	if container[capi.KeyUsage](capi.UsageServerAuth, usages) && len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
 		return fmt.Errorf("dns or ip subjectAltName is required")
 	}
 	if len(req.EmailAddresses) > 0 {
@@ -53,6 +104,14 @@ func validateAppServingCSR(req *x509.CertificateRequest, usages []capi.KeyUsage)
 	return nil
 }
 
+func formatUsages(usages []capi.KeyUsage) string {
+	quoted := make([]string, 0, len(usages))
+	for _, usage := range usages {
+		quoted = append(quoted, fmt.Sprintf("'%s'", usage))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
 func container[T capi.KeyUsage | string](slice T, slices []T) bool {
 	for _, item := range slices {
 		if item == slice {
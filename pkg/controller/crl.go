@@ -0,0 +1,333 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// crlPublisher regenerates the certificate revocation lists of every signer from the inventory
+// periodically, when the CAs change and when a certificate is revoked. Every CA of a signer holding
+// a key, the active one as well as the staged and previous ones of a rotation, signs its own list
+// of the certificates it issued. The lists are generated on every replica so that all of them
+// serve /crl, only the leader writes them to the ConfigMap.
+type crlPublisher struct {
+	client kubernetes.Interface
+	store  inventory.Store
+	// signers are the signers able to sign revocation lists
+	signers   map[string]signer.RevocationSigner
+	period    time.Duration
+	namespace string
+	name      string
+
+	// generate is keyed by signer name
+	generate workqueue.RateLimitingInterface
+	// configMap holds the name of the ConfigMap once a list changed
+	configMap workqueue.RateLimitingInterface
+
+	lock sync.RWMutex
+	// crls are the DER-encoded lists by signer name and SHA-256 fingerprint of the signing CA
+	crls map[string]map[string][]byte
+}
+
+func newCRLPublisher(client kubernetes.Interface, store inventory.Store, signers map[string]signer.Signer, opts options.CRLOptions) *crlPublisher {
+	p := &crlPublisher{
+		client:    client,
+		store:     store,
+		signers:   map[string]signer.RevocationSigner{},
+		period:    opts.UpdatePeriod,
+		namespace: opts.ConfigMapNamespace,
+		name:      opts.ConfigMapName,
+		generate:  workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "crl"),
+		configMap: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "crl_configmap"),
+		crls:      map[string]map[string][]byte{},
+	}
+	for name, s := range signers {
+		rs, ok := s.(signer.RevocationSigner)
+		if !ok {
+			klog.InfoS("Signer does not sign certificate revocation lists", "signer", name)
+			continue
+		}
+		p.signers[name] = rs
+		signerName := name
+		rs.AddListener(func() {
+			p.enqueue(signerName)
+		})
+	}
+	return p
+}
+
+// enqueue regenerates the list of a signer.
+func (p *crlPublisher) enqueue(signerName string) {
+	p.generate.Add(signerName)
+}
+
+// Start regenerates the lists until ctx is done.
+func (p *crlPublisher) Start(ctx context.Context) {
+	go func() {
+		defer utilruntime.HandleCrash()
+		defer p.generate.ShutDown()
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for signerName := range p.signers {
+				p.enqueue(signerName)
+			}
+		}, p.period)
+		go wait.UntilWithContext(ctx, p.worker, time.Second)
+		<-ctx.Done()
+	}()
+}
+
+// Run writes the lists to the ConfigMap until ctx is done.
+func (p *crlPublisher) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer p.configMap.ShutDown()
+
+	klog.InfoS("Starting certificate revocation list publisher", "configmap", klog.KRef(p.namespace, p.name))
+	defer klog.InfoS("Shutting down certificate revocation list publisher")
+
+	p.configMap.Add(p.name)
+	go wait.UntilWithContext(ctx, p.configMapWorker, time.Second)
+	<-ctx.Done()
+}
+
+func (p *crlPublisher) worker(ctx context.Context) {
+	for p.processNextItem(ctx) {
+	}
+}
+
+func (p *crlPublisher) processNextItem(ctx context.Context) bool {
+	key, quit := p.generate.Get()
+	if quit {
+		return false
+	}
+	defer p.generate.Done(key)
+
+	if err := p.sync(ctx, key.(string)); err != nil {
+		p.generate.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("generate certificate revocation list of %v failed with : %v", key, err))
+		return true
+	}
+
+	p.generate.Forget(key)
+	return true
+}
+
+func (p *crlPublisher) configMapWorker(ctx context.Context) {
+	for p.processNextConfigMap(ctx) {
+	}
+}
+
+func (p *crlPublisher) processNextConfigMap(ctx context.Context) bool {
+	key, quit := p.configMap.Get()
+	if quit {
+		return false
+	}
+	defer p.configMap.Done(key)
+
+	if err := p.syncConfigMap(ctx, key.(string)); err != nil {
+		p.configMap.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("publish certificate revocation lists to configmap %v failed with : %v", key, err))
+		return true
+	}
+
+	p.configMap.Forget(key)
+	return true
+}
+
+func (p *crlPublisher) sync(ctx context.Context, signerName string) error {
+	s, ok := p.signers[signerName]
+	if !ok {
+		return nil
+	}
+	records, err := p.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a certificate is listed by the CA that issued it, the CAs without a key cannot sign a list
+	var cas []*x509.Certificate
+	s.FindCA(func(ca *x509.Certificate) bool {
+		cas = append(cas, ca)
+		return false
+	})
+	now := time.Now()
+	crls := make(map[string][]byte, len(cas))
+	for _, ca := range cas {
+		caFingerprint := fingerprint(ca)
+		var revoked []pkix.RevokedCertificate
+		for _, record := range records {
+			// an expired certificate is rejected anyway, it is dropped from the list
+			if record.SignerName != signerName || record.CAFingerprint != caFingerprint || record.RevokedAt == nil || !now.Before(record.NotAfter) {
+				continue
+			}
+			entry, err := revokedCertificate(record)
+			if err != nil {
+				return err
+			}
+			revoked = append(revoked, entry)
+		}
+
+		ca, key := s.FindCA(func(c *x509.Certificate) bool { return fingerprint(c) == caFingerprint })
+		if ca == nil {
+			// the CA was dropped since it was listed, the CAs changed and the lists are generated again
+			continue
+		}
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			// the lists are generated independently by every replica, a timestamp keeps the number increasing
+			Number:              big.NewInt(now.UnixMilli()),
+			ThisUpdate:          now,
+			NextUpdate:          now.Add(2 * p.period),
+			RevokedCertificates: revoked,
+		}, ca, key)
+		if err != nil {
+			return fmt.Errorf("CA %q: %v", ca.Subject, err)
+		}
+		crls[caFingerprint] = crl
+		klog.V(2).InfoS("Generated certificate revocation list", "signer", signerName, "ca", ca.Subject.String(), "revoked", len(revoked))
+	}
+
+	p.lock.Lock()
+	p.crls[signerName] = crls
+	p.lock.Unlock()
+	p.configMap.Add(p.name)
+	return nil
+}
+
+// fingerprint returns the hexadecimal SHA-256 fingerprint of cert, which identifies a CA in the
+// inventory and in the URLs of its list and certificate.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func revokedCertificate(record *inventory.Record) (pkix.RevokedCertificate, error) {
+	serial, ok := new(big.Int).SetString(record.Serial, 16)
+	if !ok {
+		return pkix.RevokedCertificate{}, fmt.Errorf("invalid serial number %q in inventory", record.Serial)
+	}
+	entry := pkix.RevokedCertificate{
+		SerialNumber:   serial,
+		RevocationTime: *record.RevokedAt,
+	}
+	// RFC 5280 5.3.1: the reason code extension should be absent instead of using unspecified
+	if code := inventory.RevocationReasons[record.RevocationReason]; code != 0 {
+		value, err := asn1.Marshal(asn1.Enumerated(code))
+		if err != nil {
+			return pkix.RevokedCertificate{}, err
+		}
+		entry.Extensions = append(entry.Extensions, pkix.Extension{Id: oidExtensionReasonCode, Value: value})
+	}
+	return entry, nil
+}
+
+func (p *crlPublisher) syncConfigMap(ctx context.Context, name string) error {
+	data := map[string]string{}
+	p.lock.RLock()
+	for signerName, crls := range p.crls {
+		active := fingerprint(p.signers[signerName].Certificate())
+		for caFingerprint, crl := range crls {
+			encoded := string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}))
+			data[crlConfigMapKey(signerName, caFingerprint)] = encoded
+			if caFingerprint == active {
+				data[crlConfigMapKey(signerName, "")] = encoded
+			}
+		}
+	}
+	p.lock.RUnlock()
+	if len(data) == 0 {
+		return nil
+	}
+
+	cm, err := p.client.CoreV1().ConfigMaps(p.namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: p.namespace,
+				Labels:    map[string]string{managedByLabel: managedByValue},
+			},
+			Data: data,
+		}
+		_, err = p.client.CoreV1().ConfigMaps(p.namespace).Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(cm.Data, data) {
+		return nil
+	}
+	cm.Data = data
+	_, err = p.client.CoreV1().ConfigMaps(p.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+// ServeHTTP serves the DER-encoded list of the CA of a signer at /crl/<signer name>/<CA fingerprint>,
+// and the list of the active CA at /crl/<signer name>.
+func (p *crlPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	signerName, caFingerprint := strings.TrimPrefix(r.URL.Path, "/crl/"), ""
+	s, ok := p.signers[signerName]
+	if !ok {
+		// the signer name itself contains a slash
+		if i := strings.LastIndex(signerName, "/"); i >= 0 {
+			signerName, caFingerprint = signerName[:i], signerName[i+1:]
+			s, ok = p.signers[signerName]
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if len(caFingerprint) == 0 {
+		caFingerprint = fingerprint(s.Certificate())
+	}
+	p.lock.RLock()
+	crl, ok := p.crls[signerName][caFingerprint]
+	p.lock.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
+
+// crlConfigMapKey returns the ConfigMap key of the list of a CA of a signer, or of the active CA if
+// caFingerprint is empty. "/" is not allowed in keys.
+func crlConfigMapKey(signerName, caFingerprint string) string {
+	key := strings.ReplaceAll(signerName, "/", "_")
+	if len(caFingerprint) > 0 {
+		key += "_" + caFingerprint
+	}
+	return key + ".crl"
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/klog/v2"
+)
+
+// SignOffline issues a certificate for csr with s, configured by config, without the cluster, for break-glass issuance
+// when the apiserver is unavailable. The request goes through the same checks as the certificate
+// signing requests handled by the controller. The subjectAltName authorization looks up the
+// cluster, a signer requiring it is refused unless skipSANAuthorization is set, which is then
+// recorded. The certificate is recorded in store as the request offline-<serial> before it is returned.
+func SignOffline(ctx context.Context, s signer.Signer, config options.SignerConfiguration, store inventory.Store, csr *capi.CertificateSigningRequest, skipSANAuthorization bool) ([][]byte, error) {
+	unauthorized := false
+	if config.AuthorizeSubjectAltNames {
+		if !skipSANAuthorization {
+			return nil, fmt.Errorf("signer %s authorizes subjectAltNames, which requires the cluster, the authorization must be skipped explicitly", s.Name())
+		}
+		klog.InfoS("Skipping the subjectAltName authorization of the signer, it requires the cluster", "signer", s.Name())
+		config.AuthorizeSubjectAltNames = false
+		unauthorized = true
+	}
+	certificateRequest, reason, err := checkCertificateRequest(csr, config, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", reason, err)
+	}
+	chain, err := s.Sign(ctx, certificateRequest, csr.Spec.Usages, csr.Spec.ExpirationSeconds)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	ca, err := issuingCA(s, chain)
+	if err != nil {
+		return nil, err
+	}
+	record := inventory.NewRecord(csr, issued, ca)
+	record.CSRName = fmt.Sprintf("offline-%s", record.Serial)
+	record.SubjectAltNamesUnauthorized = unauthorized
+	if err := store.Add(ctx, record); err != nil {
+		return nil, fmt.Errorf("unable to record certificate %s in inventory: %v", inventory.FormatSerial(issued.SerialNumber), err)
+	}
+	return chain, nil
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"crypto/x509"
+	goerrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	podIPIndex     = "podIP"
+	serviceIPIndex = "serviceIP"
+
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+)
+
+// unresolvedSANError reports a subjectAltName naming a Service, or the IP of a Pod or Service, that
+// does not exist yet, e.g. because it is created along with the certificate signing request. Unlike
+// the other errors of authorize it is not final, the request is retried with a backoff.
+type unresolvedSANError struct {
+	message string
+}
+
+func (e *unresolvedSANError) Error() string {
+	return e.message
+}
+
+// isUnresolvedSAN returns whether err reports a subjectAltName that may be resolved later.
+func isUnresolvedSAN(err error) bool {
+	var unresolved *unresolvedSANError
+	return goerrors.As(err, &unresolved)
+}
+
+// sanAuthorizer only lets a service account request subjectAltNames of the Services and Pods
+// in its own namespace. It uses informers so that validating a request never calls the apiserver.
+type sanAuthorizer struct {
+	clusterDomain string
+	// resolveTimeout bounds how long after the creation of a request its subjectAltNames may be unresolved
+	resolveTimeout time.Duration
+
+	serviceLister  corelisters.ServiceLister
+	serviceIndexer cache.Indexer
+	podIndexer     cache.Indexer
+	hasSynced      []cache.InformerSynced
+}
+
+func newSANAuthorizer(informerFactory informers.SharedInformerFactory, clusterDomain string, resolveTimeout time.Duration) (*sanAuthorizer, error) {
+	serviceInformer := informerFactory.Core().V1().Services()
+	podInformer := informerFactory.Core().V1().Pods()
+
+	if err := serviceInformer.Informer().AddIndexers(cache.Indexers{serviceIPIndex: indexServiceByIP}); err != nil {
+		return nil, err
+	}
+	if err := podInformer.Informer().AddIndexers(cache.Indexers{podIPIndex: indexPodByIP}); err != nil {
+		return nil, err
+	}
+	// only the pod IPs and phases are needed, do not keep whole pods of the cluster in memory
+	if err := podInformer.Informer().SetTransform(trimPod); err != nil {
+		return nil, err
+	}
+
+	return &sanAuthorizer{
+		clusterDomain:  clusterDomain,
+		resolveTimeout: resolveTimeout,
+		serviceLister:  serviceInformer.Lister(),
+		serviceIndexer: serviceInformer.Informer().GetIndexer(),
+		podIndexer:     podInformer.Informer().GetIndexer(),
+		hasSynced:      []cache.InformerSynced{serviceInformer.Informer().HasSynced, podInformer.Informer().HasSynced},
+	}, nil
+}
+
+// authorize checks that every DNS subjectAltName is the name of a Service and every IP subjectAltName
+// is the IP of a Pod or Service in the namespace of the requesting service account. A subjectAltName
+// still unresolved resolveTimeout after the creation of the request is a permanent error, the
+// Service or Pod is not expected anymore, e.g. because of a typo.
+func (a *sanAuthorizer) authorize(csr *capi.CertificateSigningRequest, req *x509.CertificateRequest) error {
+	err := a.resolve(csr, req)
+	if isUnresolvedSAN(err) && time.Since(csr.CreationTimestamp.Time) >= a.resolveTimeout {
+		return fmt.Errorf("%v, still not resolved %v after the request was created", err, a.resolveTimeout)
+	}
+	return err
+}
+
+func (a *sanAuthorizer) resolve(csr *capi.CertificateSigningRequest, req *x509.CertificateRequest) error {
+	namespace, ok := serviceAccountNamespace(csr.Spec.Username)
+	if !ok {
+		return fmt.Errorf("requester %q is not a service account, subjectAltNames cannot be authorized", csr.Spec.Username)
+	}
+
+	for _, dnsName := range req.DNSNames {
+		name, ns, ok := a.parseServiceDNSName(dnsName)
+		if !ok {
+			return fmt.Errorf("dns subjectAltName %q is not of the form <service>.<namespace>.svc[.%s]", dnsName, a.clusterDomain)
+		}
+		if ns != namespace {
+			return fmt.Errorf("dns subjectAltName %q is outside of namespace %q", dnsName, namespace)
+		}
+		if _, err := a.serviceLister.Services(ns).Get(name); err != nil {
+			if errors.IsNotFound(err) {
+				return &unresolvedSANError{message: fmt.Sprintf("dns subjectAltName %q: service %s/%s not found", dnsName, ns, name)}
+			}
+			return err
+		}
+	}
+
+	for _, ip := range req.IPAddresses {
+		namespaces, err := a.ipNamespaces(ip.String())
+		if err != nil {
+			return err
+		}
+		if len(namespaces) == 0 {
+			return &unresolvedSANError{message: fmt.Sprintf("ip subjectAltName %q is not the IP of any pod or service", ip)}
+		}
+		if !namespaces.Has(namespace) {
+			return fmt.Errorf("ip subjectAltName %q is not the IP of a pod or service in namespace %q", ip, namespace)
+		}
+	}
+	return nil
+}
+
+// ipNamespaces returns the namespaces of the pods and services with the given IP.
+func (a *sanAuthorizer) ipNamespaces(ip string) (sets.Set[string], error) {
+	namespaces := sets.New[string]()
+	for _, index := range []struct {
+		indexer cache.Indexer
+		name    string
+	}{
+		{a.podIndexer, podIPIndex},
+		{a.serviceIndexer, serviceIPIndex},
+	} {
+		objs, err := index.indexer.ByIndex(index.name, ip)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			namespaces.Insert(obj.(metav1.Object).GetNamespace())
+		}
+	}
+	return namespaces, nil
+}
+
+// parseServiceDNSName splits <service>.<namespace>.svc and <service>.<namespace>.svc.<cluster domain>.
+func (a *sanAuthorizer) parseServiceDNSName(dnsName string) (string, string, bool) {
+	dnsName = strings.TrimSuffix(dnsName, ".")
+	if trimmed := strings.TrimSuffix(dnsName, "."+a.clusterDomain); trimmed != dnsName {
+		dnsName = trimmed
+	}
+	parts := strings.Split(dnsName, ".")
+	if len(parts) != 3 || parts[2] != "svc" || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// serviceAccountNamespace returns the namespace of a system:serviceaccount:<namespace>:<name> username.
+func serviceAccountNamespace(username string) (string, bool) {
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) {
+		return "", false
+	}
+	namespace, name, found := strings.Cut(strings.TrimPrefix(username, serviceAccountUsernamePrefix), ":")
+	if !found || len(namespace) == 0 || len(name) == 0 || strings.Contains(name, ":") {
+		return "", false
+	}
+	return namespace, true
+}
+
+func indexPodByIP(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	// host network pods share the node IP, it does not belong to their namespace
+	if pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	// the IPs of terminated pods are released and may already belong to another pod
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return nil, nil
+	}
+	ips := make([]string, 0, len(pod.Status.PodIPs))
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	return ips, nil
+}
+
+func indexServiceByIP(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != corev1.ClusterIPNone {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+func trimPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Spec: corev1.PodSpec{
+			HostNetwork: pod.Spec.HostNetwork,
+		},
+		Status: corev1.PodStatus{
+			Phase:  pod.Status.Phase,
+			PodIPs: pod.Status.PodIPs,
+		},
+	}, nil
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	capiv1alpha1 "k8s.io/api/certificates/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certificatesv1alpha1listers "k8s.io/client-go/listers/certificates/v1alpha1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// trustBundleNameSuffix is appended to the signer name prefix to form the ClusterTrustBundle name.
+	trustBundleNameSuffix = "ca"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "certificate-controller"
+)
+
+// trustBundlePublisher maintains a ClusterTrustBundle per signer holding the CA certificates
+// that verify its certificates. It is reconciled when the CA of a signer changes and when
+// the ClusterTrustBundle is edited or deleted.
+type trustBundlePublisher struct {
+	client    kubernetes.Interface
+	ctbLister certificatesv1alpha1listers.ClusterTrustBundleLister
+	hasSynced cache.InformerSynced
+	queue     workqueue.RateLimitingInterface
+	signers   map[string]signer.Signer
+	// names maps the ClusterTrustBundle names back to their signer
+	names map[string]string
+}
+
+func newTrustBundlePublisher(client kubernetes.Interface, informerFactory informers.SharedInformerFactory, signers map[string]signer.Signer) *trustBundlePublisher {
+	ctbInformer := informerFactory.Certificates().V1alpha1().ClusterTrustBundles()
+	p := &trustBundlePublisher{
+		client:    client,
+		ctbLister: ctbInformer.Lister(),
+		hasSynced: ctbInformer.Informer().HasSynced,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "trust_bundle"),
+		signers:   signers,
+		names:     map[string]string{},
+	}
+	for name, s := range signers {
+		signerName := name
+		p.names[trustBundleName(signerName)] = signerName
+		s.AddListener(func() {
+			p.queue.Add(signerName)
+		})
+	}
+
+	ctbInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: p.enqueueTrustBundle,
+		UpdateFunc: func(_, newObj interface{}) {
+			p.enqueueTrustBundle(newObj)
+		},
+		DeleteFunc: p.enqueueTrustBundle,
+	})
+	return p
+}
+
+func (p *trustBundlePublisher) enqueueTrustBundle(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key from object %+v: %+v", obj, err))
+		return
+	}
+	if signerName, ok := p.names[key]; ok {
+		p.queue.Add(signerName)
+	}
+}
+
+func (p *trustBundlePublisher) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer p.queue.ShutDown()
+
+	klog.InfoS("Starting cluster trust bundle publisher")
+	defer klog.InfoS("Shutting down cluster trust bundle publisher")
+
+	if !cache.WaitForNamedCacheSync("trust_bundle", ctx.Done(), p.hasSynced) {
+		return
+	}
+	for signerName := range p.signers {
+		p.queue.Add(signerName)
+	}
+	go wait.UntilWithContext(ctx, p.worker, time.Second)
+	<-ctx.Done()
+}
+
+func (p *trustBundlePublisher) worker(ctx context.Context) {
+	for p.processNextItem(ctx) {
+	}
+}
+
+func (p *trustBundlePublisher) processNextItem(ctx context.Context) bool {
+	key, quit := p.queue.Get()
+	if quit {
+		return false
+	}
+	defer p.queue.Done(key)
+
+	if err := p.sync(ctx, key.(string)); err != nil {
+		p.queue.AddRateLimited(key)
+		utilruntime.HandleError(fmt.Errorf("publish trust bundle of %v failed with : %v", key, err))
+		return true
+	}
+
+	p.queue.Forget(key)
+	return true
+}
+
+func (p *trustBundlePublisher) sync(ctx context.Context, signerName string) error {
+	s, ok := p.signers[signerName]
+	if !ok {
+		return nil
+	}
+	name := trustBundleName(signerName)
+	bundle := string(s.TrustBundle())
+
+	ctb, err := p.ctbLister.Get(name)
+	if errors.IsNotFound(err) {
+		ctb = &capiv1alpha1.ClusterTrustBundle{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{managedByLabel: managedByValue},
+			},
+			Spec: capiv1alpha1.ClusterTrustBundleSpec{
+				SignerName:  signerName,
+				TrustBundle: bundle,
+			},
+		}
+		_, err = p.client.CertificatesV1alpha1().ClusterTrustBundles().Create(ctx, ctb, metav1.CreateOptions{})
+		if err == nil {
+			klog.InfoS("Created cluster trust bundle", "name", name, "signer", signerName)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if ctb.Spec.SignerName == signerName && ctb.Spec.TrustBundle == bundle {
+		return nil
+	}
+
+	ctb = ctb.DeepCopy()
+	ctb.Spec.SignerName = signerName
+	ctb.Spec.TrustBundle = bundle
+	_, err = p.client.CertificatesV1alpha1().ClusterTrustBundles().Update(ctx, ctb, metav1.UpdateOptions{})
+	if err == nil {
+		klog.InfoS("Updated cluster trust bundle", "name", name, "signer", signerName)
+	}
+	return err
+}
+
+// trustBundleName returns the ClusterTrustBundle name of a signer. A ClusterTrustBundle linked
+// to a signer must be named after it, with "/" replaced by ":".
+func trustBundleName(signerName string) string {
+	return strings.ReplaceAll(signerName, "/", ":") + ":" + trustBundleNameSuffix
+}
@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// fileStore appends one JSON record per line to a local file, a record appended again for the same
+// serial, e.g. once revoked, replaces the previous one. The file is indexed in memory and the lines
+// appended by other processes are picked up before every query.
+type fileStore struct {
+	filename string
+
+	lock sync.Mutex
+	// offset is the size of the file already indexed
+	offset  int64
+	records map[string]*Record
+	// serials are the serials in the order they were recorded
+	serials []string
+}
+
+// NewFileStore opens the append-only inventory file, creating it if needed.
+func NewFileStore(filename string) (Store, error) {
+	s := &fileStore{
+		filename: filename,
+		records:  map[string]*Record{},
+	}
+	f, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) Add(_ context.Context, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := os.OpenFile(s.filename, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// a recorded certificate is never lost once Add returns
+	return f.Sync()
+}
+
+func (s *fileStore) Get(_ context.Context, serial string) (*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	record, ok := s.records[serial]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *fileStore) ListBySAN(_ context.Context, san string) ([]*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for _, serial := range s.serials {
+		if record := s.records[serial]; record.HasSAN(san) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *fileStore) List(_ context.Context) ([]*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	records := make([]*Record, 0, len(s.serials))
+	for _, serial := range s.serials {
+		records = append(records, s.records[serial])
+	}
+	return records, nil
+}
+
+// refresh indexes the complete lines appended to the file since the last call.
+func (s *fileStore) refresh() error {
+	f, err := os.Open(s.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial line is being written, it is read again on the next refresh
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start := s.offset
+		s.offset += int64(len(line))
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return fmt.Errorf("inventory file %q is corrupted at offset %d: %v", s.filename, start, err)
+		}
+		if _, ok := s.records[record.Serial]; !ok {
+			s.serials = append(s.serials, record.Serial)
+		}
+		s.records[record.Serial] = record
+	}
+}
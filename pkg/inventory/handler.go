@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+// NewHandler serves the records of store as a JSON list, queried by ?serial=<hex serial> or ?san=<subjectAltName>.
+func NewHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		records := []*Record{}
+		switch serial, san := query.Get("serial"), query.Get("san"); {
+		case len(serial) > 0 && len(san) == 0:
+			serial, err := NormalizeSerial(serial)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			record, err := store.Get(r.Context(), serial)
+			if errors.Is(err, ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				klog.ErrorS(err, "Unable to query inventory", "serial", serial)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			records = append(records, record)
+		case len(san) > 0 && len(serial) == 0:
+			found, err := store.ListBySAN(r.Context(), san)
+			if err != nil {
+				klog.ErrorS(err, "Unable to query inventory", "san", san)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			records = append(records, found...)
+		default:
+			http.Error(w, "exactly one of the serial or san query parameters is required", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			klog.ErrorS(err, "Unable to write inventory response")
+		}
+	})
+}
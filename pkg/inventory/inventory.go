@@ -0,0 +1,168 @@
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNotFound is returned when no certificate with the requested serial has been recorded.
+var ErrNotFound = errors.New("certificate not found in inventory")
+
+// Record describes an issued certificate.
+type Record struct {
+	// Serial is the lowercase hexadecimal serial number of the certificate, without separators.
+	Serial         string    `json:"serial"`
+	Subject        string    `json:"subject"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	SignerName     string    `json:"signerName"`
+	// CAFingerprint is the hexadecimal SHA-256 fingerprint of the CA certificate that issued the certificate.
+	CAFingerprint string `json:"caFingerprint"`
+
+	// CSRName, Username, UID and Groups identify the certificate signing request and its requester.
+	CSRName  string   `json:"csrName"`
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	// SubjectAltNamesUnauthorized is set when the certificate was issued offline without the
+	// subjectAltName authorization its signer requires.
+	SubjectAltNamesUnauthorized bool `json:"subjectAltNamesUnauthorized,omitempty"`
+
+	// RevokedAt is set once the certificate is revoked, for the reason RevocationReason.
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	RevocationReason string     `json:"revocationReason,omitempty"`
+}
+
+// NewRecord describes the certificate issued by ca for csr.
+func NewRecord(csr *capi.CertificateSigningRequest, issued, ca *x509.Certificate) *Record {
+	fingerprint := sha256.Sum256(ca.Raw)
+	r := &Record{
+		Serial:         FormatSerial(issued.SerialNumber),
+		Subject:        issued.Subject.String(),
+		DNSNames:       issued.DNSNames,
+		EmailAddresses: issued.EmailAddresses,
+		NotBefore:      issued.NotBefore.UTC(),
+		NotAfter:       issued.NotAfter.UTC(),
+		SignerName:     csr.Spec.SignerName,
+		CAFingerprint:  hex.EncodeToString(fingerprint[:]),
+		CSRName:        csr.Name,
+		Username:       csr.Spec.Username,
+		UID:            csr.Spec.UID,
+		Groups:         csr.Spec.Groups,
+	}
+	for _, ip := range issued.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, uri := range issued.URIs {
+		r.URIs = append(r.URIs, uri.String())
+	}
+	return r
+}
+
+// HasSAN returns whether san is one of the subjectAltNames of the certificate.
+func (r *Record) HasSAN(san string) bool {
+	for _, sans := range [][]string{r.DNSNames, r.IPAddresses, r.EmailAddresses, r.URIs} {
+		for _, s := range sans {
+			if strings.EqualFold(s, san) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Store persists the issued certificates.
+type Store interface {
+	// Add records an issued certificate, replacing the record with the same serial if any.
+	Add(ctx context.Context, record *Record) error
+	// Get returns the certificate with the given serial, as formatted by NormalizeSerial, or ErrNotFound.
+	Get(ctx context.Context, serial string) (*Record, error)
+	// ListBySAN returns the certificates having san as subjectAltName.
+	ListBySAN(ctx context.Context, san string) ([]*Record, error)
+	// List returns all the certificates.
+	List(ctx context.Context) ([]*Record, error)
+}
+
+// RevocationReasons maps the CRLReason names of RFC 5280 to their code.
+var RevocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// Revoke marks the certificate with the given serial as revoked at the given time. It returns the
+// record and whether it was revoked by this call, a certificate already revoked is left unchanged.
+func Revoke(ctx context.Context, store Store, serial, reason string, at time.Time) (*Record, bool, error) {
+	if len(reason) == 0 {
+		reason = "unspecified"
+	}
+	if _, ok := RevocationReasons[reason]; !ok {
+		return nil, false, fmt.Errorf("unknown revocation reason %q", reason)
+	}
+	record, err := store.Get(ctx, serial)
+	if err != nil {
+		return nil, false, err
+	}
+	if record.RevokedAt != nil {
+		return record, false, nil
+	}
+	revoked := *record
+	at = at.UTC()
+	revoked.RevokedAt = &at
+	revoked.RevocationReason = reason
+	if err := store.Add(ctx, &revoked); err != nil {
+		return nil, false, err
+	}
+	return &revoked, true, nil
+}
+
+// NewStore returns the store configured by opts, or nil if the inventory is disabled.
+func NewStore(client kubernetes.Interface, opts options.InventoryOptions) (Store, error) {
+	switch opts.Backend {
+	case "":
+		return nil, nil
+	case options.InventoryBackendFile:
+		return NewFileStore(opts.File)
+	case options.InventoryBackendConfigMap:
+		return newShardedStore(&configMapShards{client: client, namespace: opts.Namespace}, opts.Name, opts.Shards, opts.Retention), nil
+	case options.InventoryBackendSecret:
+		return newShardedStore(&secretShards{client: client, namespace: opts.Namespace}, opts.Name, opts.Shards, opts.Retention), nil
+	}
+	return nil, fmt.Errorf("unknown inventory backend %q", opts.Backend)
+}
+
+// FormatSerial formats a serial number as stored in the inventory.
+func FormatSerial(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// NormalizeSerial accepts a hexadecimal serial number, optionally separated by colons
+// as printed by openssl, and formats it as stored in the inventory.
+func NormalizeSerial(serial string) (string, error) {
+	n, ok := new(big.Int).SetString(strings.ReplaceAll(serial, ":", ""), 16)
+	if !ok || n.Sign() < 0 {
+		return "", fmt.Errorf("invalid serial number %q", serial)
+	}
+	return FormatSerial(n), nil
+}
@@ -0,0 +1,320 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// inventoryLabel is set to the name prefix on every shard of an inventory.
+	inventoryLabel = "cms.io/inventory"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "certificate-controller"
+
+	// maxShardSize keeps a shard below the 1MiB limit of ConfigMaps and Secrets, with room for the metadata.
+	maxShardSize = 900 * 1024
+)
+
+// shard is a ConfigMap or Secret holding the records of the serials hashed to it, keyed by serial.
+type shard struct {
+	name            string
+	resourceVersion string
+	labels          map[string]string
+	records         map[string][]byte
+}
+
+// shardClient reads and writes the shards as ConfigMaps or Secrets.
+type shardClient interface {
+	get(ctx context.Context, name string) (*shard, error)
+	list(ctx context.Context, selector labels.Selector) ([]*shard, error)
+	create(ctx context.Context, s *shard) error
+	update(ctx context.Context, s *shard) error
+}
+
+// shardedStore spreads the records over a fixed number of ConfigMaps or Secrets by serial. The
+// records of certificates expired for longer than retention are dropped from a shard when it is
+// written, so that a shard only fills up with the certificates issued within their lifetime.
+type shardedStore struct {
+	client    shardClient
+	name      string
+	shards    int
+	retention time.Duration
+}
+
+func newShardedStore(client shardClient, name string, shards int, retention time.Duration) *shardedStore {
+	return &shardedStore{
+		client:    client,
+		name:      name,
+		shards:    shards,
+		retention: retention,
+	}
+}
+
+func (s *shardedStore) shardName(serial string) string {
+	h := fnv.New32a()
+	h.Write([]byte(serial))
+	return fmt.Sprintf("%s-%d", s.name, h.Sum32()%uint32(s.shards))
+}
+
+func (s *shardedStore) selector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{inventoryLabel: s.name})
+}
+
+func (s *shardedStore) Add(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	name := s.shardName(record.Serial)
+
+	// another replica may be writing the same shard, retry on conflicting updates and creations
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		sh, err := s.client.get(ctx, name)
+		if apierrors.IsNotFound(err) {
+			return s.client.create(ctx, &shard{
+				name:    name,
+				labels:  map[string]string{inventoryLabel: s.name, managedByLabel: managedByValue},
+				records: map[string][]byte{record.Serial: data},
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if sh.records == nil {
+			sh.records = map[string][]byte{}
+		}
+		s.pruneExpired(sh, time.Now())
+		sh.records[record.Serial] = data
+		if size := shardSize(sh); size > maxShardSize {
+			return fmt.Errorf("inventory shard %q is full (%d bytes) with certificates expired for less than the retention, increase the number of shards or reduce the retention", name, size)
+		}
+		return s.client.update(ctx, sh)
+	})
+}
+
+func (s *shardedStore) Get(ctx context.Context, serial string) (*Record, error) {
+	sh, err := s.client.get(ctx, s.shardName(serial))
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		if data, ok := sh.records[serial]; ok {
+			return decodeRecord(sh.name, data)
+		}
+	}
+
+	// the record may have been written before the number of shards changed
+	shards, err := s.client.list(ctx, s.selector())
+	if err != nil {
+		return nil, err
+	}
+	for _, sh := range shards {
+		if data, ok := sh.records[serial]; ok {
+			return decodeRecord(sh.name, data)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *shardedStore) ListBySAN(ctx context.Context, san string) ([]*Record, error) {
+	all, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for _, record := range all {
+		if record.HasSAN(san) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// List returns the records of all shards, oldest first.
+func (s *shardedStore) List(ctx context.Context) ([]*Record, error) {
+	shards, err := s.client.list(ctx, s.selector())
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for _, sh := range shards {
+		for _, data := range sh.records {
+			record, err := decodeRecord(sh.name, data)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NotBefore.Before(records[j].NotBefore)
+	})
+	return records, nil
+}
+
+// pruneExpired drops the records of the certificates expired for longer than the retention. A
+// corrupted record is kept, so that it is reported by the readers rather than silently lost.
+func (s *shardedStore) pruneExpired(sh *shard, now time.Time) {
+	for serial, data := range sh.records {
+		record := &Record{}
+		if err := json.Unmarshal(data, record); err != nil {
+			continue
+		}
+		if now.After(record.NotAfter.Add(s.retention)) {
+			delete(sh.records, serial)
+		}
+	}
+}
+
+func decodeRecord(shardName string, data []byte) (*Record, error) {
+	record := &Record{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("inventory shard %q is corrupted: %v", shardName, err)
+	}
+	return record, nil
+}
+
+func shardSize(s *shard) int {
+	size := 0
+	for serial, data := range s.records {
+		size += len(serial) + len(data)
+	}
+	return size
+}
+
+type configMapShards struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (c *configMapShards) get(ctx context.Context, name string) (*shard, error) {
+	cm, err := c.client.CoreV1().ConfigMaps(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return configMapToShard(cm), nil
+}
+
+func (c *configMapShards) list(ctx context.Context, selector labels.Selector) ([]*shard, error) {
+	cms, err := c.client.CoreV1().ConfigMaps(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*shard, 0, len(cms.Items))
+	for i := range cms.Items {
+		shards = append(shards, configMapToShard(&cms.Items[i]))
+	}
+	return shards, nil
+}
+
+func (c *configMapShards) create(ctx context.Context, s *shard) error {
+	_, err := c.client.CoreV1().ConfigMaps(c.namespace).Create(ctx, c.shardToConfigMap(s), metav1.CreateOptions{})
+	return err
+}
+
+// update replaces the shard, failing with a conflict if it changed since it was read.
+func (c *configMapShards) update(ctx context.Context, s *shard) error {
+	_, err := c.client.CoreV1().ConfigMaps(c.namespace).Update(ctx, c.shardToConfigMap(s), metav1.UpdateOptions{})
+	return err
+}
+
+func (c *configMapShards) shardToConfigMap(s *shard) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.name,
+			Namespace:       c.namespace,
+			ResourceVersion: s.resourceVersion,
+			Labels:          s.labels,
+		},
+		Data: make(map[string]string, len(s.records)),
+	}
+	for serial, record := range s.records {
+		cm.Data[serial] = string(record)
+	}
+	return cm
+}
+
+func configMapToShard(cm *corev1.ConfigMap) *shard {
+	s := &shard{
+		name:            cm.Name,
+		resourceVersion: cm.ResourceVersion,
+		labels:          cm.Labels,
+		records:         make(map[string][]byte, len(cm.Data)),
+	}
+	for serial, data := range cm.Data {
+		s.records[serial] = []byte(data)
+	}
+	return s
+}
+
+type secretShards struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (c *secretShards) get(ctx context.Context, name string) (*shard, error) {
+	secret, err := c.client.CoreV1().Secrets(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretToShard(secret), nil
+}
+
+func (c *secretShards) list(ctx context.Context, selector labels.Selector) ([]*shard, error) {
+	secrets, err := c.client.CoreV1().Secrets(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*shard, 0, len(secrets.Items))
+	for i := range secrets.Items {
+		shards = append(shards, secretToShard(&secrets.Items[i]))
+	}
+	return shards, nil
+}
+
+func (c *secretShards) create(ctx context.Context, s *shard) error {
+	_, err := c.client.CoreV1().Secrets(c.namespace).Create(ctx, c.shardToSecret(s), metav1.CreateOptions{})
+	return err
+}
+
+// update replaces the shard, failing with a conflict if it changed since it was read.
+func (c *secretShards) update(ctx context.Context, s *shard) error {
+	_, err := c.client.CoreV1().Secrets(c.namespace).Update(ctx, c.shardToSecret(s), metav1.UpdateOptions{})
+	return err
+}
+
+func (c *secretShards) shardToSecret(s *shard) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.name,
+			Namespace:       c.namespace,
+			ResourceVersion: s.resourceVersion,
+			Labels:          s.labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: s.records,
+	}
+}
+
+func secretToShard(secret *corev1.Secret) *shard {
+	return &shard{
+		name:            secret.Name,
+		resourceVersion: secret.ResourceVersion,
+		labels:          secret.Labels,
+		records:         secret.Data,
+	}
+}
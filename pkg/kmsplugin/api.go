@@ -0,0 +1,8 @@
+package kmsplugin
+
+const (
+	// APIVersion is the version of the KeyService API reported by Status.
+	APIVersion = "v1"
+	// HealthzOK is the healthz of a key service able to sign.
+	HealthzOK = "ok"
+)
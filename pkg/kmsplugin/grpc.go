@@ -0,0 +1,46 @@
+package kmsplugin
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ParseEndpoint returns the path of the unix socket of endpoint, of the form unix:///path/to/socket.
+func ParseEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	if u.Scheme != "unix" || len(u.Path) == 0 {
+		return "", fmt.Errorf("invalid endpoint %q: only unix:///path/to/socket endpoints are supported", endpoint)
+	}
+	return u.Path, nil
+}
+
+// conns are the gRPC connections by socket. A key is created again whenever its CA is reloaded,
+// sharing the connection reuses the one of the previous key instead of leaking it.
+var conns = struct {
+	sync.Mutex
+	bySocket map[string]*grpc.ClientConn
+}{bySocket: map[string]*grpc.ClientConn{}}
+
+// dial returns the connection to the key service listening on socket. The connection is
+// established lazily and reconnects by itself, a call fails fast while the service is down.
+func dial(socket string) (*grpc.ClientConn, error) {
+	conns.Lock()
+	defer conns.Unlock()
+	if conn, ok := conns.bySocket[socket]; ok {
+		return conn, nil
+	}
+	// gRPC over the unix socket is not encrypted, the socket is protected by its file permissions
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	conns.bySocket[socket] = conn
+	return conn, nil
+}
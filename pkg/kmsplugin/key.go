@@ -0,0 +1,177 @@
+package kmsplugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"time"
+
+	kmsapi "github.com/ericpuwang/certificate-controller/pkg/kmsplugin/v1"
+)
+
+// DefaultTimeout bounds the calls to a key service whose timeout is not configured.
+const DefaultTimeout = 3 * time.Second
+
+// Key is a crypto.Signer whose private key is held by a key service, every signature being
+// computed by a Sign call to the service.
+type Key struct {
+	endpoint string
+	client   kmsapi.KeyServiceClient
+	timeout  time.Duration
+	keyID    string
+	public   crypto.PublicKey
+}
+
+// NewKey connects to the key service listening on endpoint, checks it is healthy and fetches its
+// public key. Every call is bounded by timeout, DefaultTimeout if it is zero.
+func NewKey(ctx context.Context, endpoint string, timeout time.Duration) (*Key, error) {
+	socket, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	conn, err := dial(socket)
+	if err != nil {
+		return nil, fmt.Errorf("key service %s: %v", endpoint, err)
+	}
+	k := &Key{
+		endpoint: endpoint,
+		client:   kmsapi.NewKeyServiceClient(conn),
+		timeout:  timeout,
+	}
+	if err := k.Check(ctx); err != nil {
+		return nil, err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+	resp, err := k.client.PublicKey(callCtx, &kmsapi.PublicKeyRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("key service %s: %v", endpoint, err)
+	}
+	public, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("key service %s: invalid public key: %v", endpoint, err)
+	}
+	k.public = public
+	k.keyID = resp.KeyId
+	return k, nil
+}
+
+// Check calls Status and fails unless the key service reports it is healthy.
+func (k *Key) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+	resp, err := k.client.Status(ctx, &kmsapi.StatusRequest{})
+	if err != nil {
+		return fmt.Errorf("key service %s: %v", k.endpoint, err)
+	}
+	if resp.Version != APIVersion {
+		return fmt.Errorf("key service %s: unsupported API version %q, expected %q", k.endpoint, resp.Version, APIVersion)
+	}
+	if resp.Healthz != HealthzOK {
+		return fmt.Errorf("key service %s: unhealthy: %s", k.endpoint, resp.Healthz)
+	}
+	return nil
+}
+
+// String identifies the key in errors and logs.
+func (k *Key) String() string {
+	if len(k.keyID) == 0 {
+		return k.endpoint
+	}
+	return fmt.Sprintf("%s (key %q)", k.endpoint, k.keyID)
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign sends digest to the key service, rand is ignored.
+func (k *Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.sign(context.Background(), digest, opts)
+}
+
+// WithContext returns the key with its Sign calls canceled when ctx is done.
+func (k *Key) WithContext(ctx context.Context) crypto.Signer {
+	return &contextKey{key: k, ctx: ctx}
+}
+
+func (k *Key) sign(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := signatureAlgorithm(k.public, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+	resp, err := k.client.Sign(ctx, &kmsapi.SignRequest{Digest: digest, Algorithm: algorithm})
+	if err != nil {
+		return nil, fmt.Errorf("key service %s: %v", k.endpoint, err)
+	}
+	if len(resp.Signature) == 0 {
+		return nil, fmt.Errorf("key service %s: empty signature", k.endpoint)
+	}
+	return resp.Signature, nil
+}
+
+type contextKey struct {
+	key *Key
+	ctx context.Context
+}
+
+func (k *contextKey) Public() crypto.PublicKey {
+	return k.key.public
+}
+
+func (k *contextKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.key.sign(k.ctx, digest, opts)
+}
+
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+// signatureAlgorithm names the algorithm of a signature by the public key with opts.
+func signatureAlgorithm(public crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	if _, ok := public.(ed25519.PublicKey); ok {
+		if opts.HashFunc() != crypto.Hash(0) {
+			return "", fmt.Errorf("ed25519 signs the message, not a %v digest", opts.HashFunc())
+		}
+		return "ED25519", nil
+	}
+	name, ok := hashNames[opts.HashFunc()]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+	if pss, ok := opts.(*rsa.PSSOptions); ok {
+		if pss.SaltLength != rsa.PSSSaltLengthEqualsHash {
+			return "", fmt.Errorf("only RSA-PSS signatures with a salt as long as the hash are supported")
+		}
+		return name + "-PSS", nil
+	}
+	return name, nil
+}
+
+// parseSignatureAlgorithm returns the SignerOpts of algorithm.
+func parseSignatureAlgorithm(algorithm string) (crypto.SignerOpts, error) {
+	if algorithm == "ED25519" {
+		return crypto.Hash(0), nil
+	}
+	for hash, name := range hashNames {
+		switch algorithm {
+		case name:
+			return hash, nil
+		case name + "-PSS":
+			return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+}
@@ -0,0 +1,184 @@
+package kmsplugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveKey serves key with the reference Server on a unix socket in a temporary directory and
+// returns its endpoint. The server stops at the end of the test.
+func serveKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "kms.sock")
+	endpoint := "unix://" + socket
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(key, "test-key").Serve(ctx, endpoint)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+
+	// the socket exists once the server listens
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			return endpoint
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key service did not listen on %s", socket)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("certificate-controller")
+	digest := sha256.Sum256(message)
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		signed []byte
+		opts   crypto.SignerOpts
+		verify func(signature []byte) bool
+	}{
+		{
+			name:   "ecdsa",
+			key:    ecKey,
+			signed: digest[:],
+			opts:   crypto.SHA256,
+			verify: func(signature []byte) bool {
+				return ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], signature)
+			},
+		},
+		{
+			name:   "rsa",
+			key:    rsaKey,
+			signed: digest[:],
+			opts:   crypto.SHA256,
+			verify: func(signature []byte) bool {
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature) == nil
+			},
+		},
+		{
+			name:   "rsa-pss",
+			key:    rsaKey,
+			signed: digest[:],
+			opts:   pss,
+			verify: func(signature []byte) bool {
+				return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], signature, pss) == nil
+			},
+		},
+		{
+			name:   "ed25519",
+			key:    edKey,
+			signed: message,
+			opts:   crypto.Hash(0),
+			verify: func(signature []byte) bool {
+				return ed25519.Verify(edKey.Public().(ed25519.PublicKey), message, signature)
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := NewKey(context.Background(), serveKey(t, tc.key), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Fatalf("public key %T of the key service does not match the local key", key.Public())
+			}
+			signature, err := key.Sign(rand.Reader, tc.signed, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.verify(signature) {
+				t.Fatal("signature does not verify with the local key")
+			}
+		})
+	}
+}
+
+// TestKeyCertificate checks the key signs certificates like a local key, with its context.
+func TestKeyCertificate(t *testing.T) {
+	local, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(context.Background(), serveKey(t, local), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kms CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key.WithContext(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.CheckSignatureFrom(ca); err != nil {
+		t.Fatalf("certificate signed by the key service does not verify: %v", err)
+	}
+	if !local.PublicKey.Equal(ca.PublicKey) {
+		t.Fatal("certificate does not hold the public key of the local key")
+	}
+}
+
+// TestKeyWrongAlgorithm checks the server refuses a digest that does not match the algorithm.
+func TestKeyWrongAlgorithm(t *testing.T) {
+	local, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKey(context.Background(), serveKey(t, local), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("certificate-controller"))
+	if _, err := key.Sign(rand.Reader, digest[:], crypto.SHA384); err == nil {
+		t.Fatal("expected a SHA-256 digest signed as SHA384 to be refused")
+	}
+}
@@ -0,0 +1,90 @@
+package kmsplugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	kmsapi "github.com/ericpuwang/certificate-controller/pkg/kmsplugin/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Server is a reference KeyService signing with a local key. It stands in for a key management
+// system to run and test the controller locally, and is the model of an actual key service.
+type Server struct {
+	kmsapi.UnimplementedKeyServiceServer
+
+	key   crypto.Signer
+	keyID string
+}
+
+// NewServer serves key identified by keyID.
+func NewServer(key crypto.Signer, keyID string) *Server {
+	return &Server{key: key, keyID: keyID}
+}
+
+// Serve listens on the unix socket of endpoint until ctx is done. A socket left over by a previous
+// server is removed.
+func (s *Server) Serve(ctx context.Context, endpoint string) error {
+	socket, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing socket %q: %v", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyServiceServer(server, s)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	klog.InfoS("Serving key service", "endpoint", endpoint, "keyID", s.keyID)
+	return server.Serve(listener)
+}
+
+func (s *Server) Status(context.Context, *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	return &kmsapi.StatusResponse{Version: APIVersion, Healthz: HealthzOK, KeyId: s.keyID}, nil
+}
+
+func (s *Server) PublicKey(context.Context, *kmsapi.PublicKeyRequest) (*kmsapi.PublicKeyResponse, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &kmsapi.PublicKeyResponse{PublicKey: der, KeyId: s.keyID}, nil
+}
+
+func (s *Server) Sign(_ context.Context, req *kmsapi.SignRequest) (*kmsapi.SignResponse, error) {
+	opts, err := parseSignatureAlgorithm(req.Algorithm)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	_, ed := s.key.Public().(ed25519.PublicKey)
+	switch {
+	case ed != (opts.HashFunc() == crypto.Hash(0)):
+		return nil, status.Errorf(codes.InvalidArgument, "algorithm %s does not match the %T key", req.Algorithm, s.key.Public())
+	case !ed && len(req.Digest) != opts.HashFunc().Size():
+		return nil, status.Errorf(codes.InvalidArgument, "digest of %d bytes is not a %s digest", len(req.Digest), req.Algorithm)
+	}
+	signature, err := s.key.Sign(rand.Reader, req.Digest, opts)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &kmsapi.SignResponse{Signature: signature}, nil
+}
@@ -0,0 +1,5 @@
+// Package v1 is the KeyService API of the key services holding the private key of a CA, generated
+// from api.proto with protoc, protoc-gen-go v1.30.0 and protoc-gen-go-grpc v1.3.0.
+package v1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative api.proto
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	// register the workqueue metrics provider, the "certificate" queue reports through it
+	_ "k8s.io/component-base/metrics/prometheus/workqueue"
+)
+
+const subsystem = "certificate_controller"
+
+var (
+	// SignedTotal counts the certificates issued per signer.
+	SignedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      subsystem,
+			Name:           "csr_signed_total",
+			Help:           "Number of certificate signing requests signed, partitioned by signer name.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"signer_name"},
+	)
+
+	// RejectedTotal counts the certificate signing requests that could not be signed.
+	RejectedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      subsystem,
+			Name:           "csr_rejected_total",
+			Help:           "Number of certificate signing requests that could not be signed, partitioned by signer name and reason.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"signer_name", "reason"},
+	)
+
+	// InventoryErrorsTotal counts the issued certificates that could not be recorded in the inventory.
+	InventoryErrorsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      subsystem,
+			Name:           "inventory_errors_total",
+			Help:           "Number of issued certificates that could not be recorded in the inventory, partitioned by signer name.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"signer_name"},
+	)
+
+	// SignDuration observes how long the signer takes to issue a certificate.
+	SignDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      subsystem,
+			Name:           "sign_duration_seconds",
+			Help:           "Latency of signing a certificate in seconds, partitioned by signer name.",
+			Buckets:        metrics.ExponentialBuckets(0.0005, 2, 14),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"signer_name"},
+	)
+
+	caExpirationDesc = metrics.NewDesc(
+		metrics.BuildFQName("", subsystem, "signing_ca_expiration_seconds"),
+		"Seconds until the NotAfter of the signing CA certificate, partitioned by signer name.",
+		[]string{"signer_name"},
+		nil,
+		metrics.ALPHA,
+		"",
+	)
+
+	caRotationPhaseDesc = metrics.NewDesc(
+		metrics.BuildFQName("", subsystem, "ca_rotation_phase"),
+		"The CA rotation phase of the signer, stable, staged or overlap, set to 1 for the current phase.",
+		[]string{"signer_name", "phase"},
+		nil,
+		metrics.ALPHA,
+		"",
+	)
+)
+
+var registerMetrics sync.Once
+
+// Register registers all metrics of the certificate controller with the legacy registry.
+func Register() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(SignedTotal)
+		legacyregistry.MustRegister(RejectedTotal)
+		legacyregistry.MustRegister(InventoryErrorsTotal)
+		legacyregistry.MustRegister(SignDuration)
+		legacyregistry.CustomMustRegister(signingCAs)
+	})
+}
+
+// RegisterSigningCA reports the remaining lifetime of the CA returned by notAfter under signerName.
+// notAfter is evaluated on every scrape so that a reloaded CA is reflected immediately.
+func RegisterSigningCA(signerName string, notAfter func() time.Time) {
+	signingCAs.lock.Lock()
+	defer signingCAs.lock.Unlock()
+	signingCAs.notAfter[signerName] = notAfter
+}
+
+// RegisterCARotation reports the CA rotation phase returned by phase under signerName.
+// phase is evaluated on every scrape.
+func RegisterCARotation(signerName string, phase func() string) {
+	signingCAs.lock.Lock()
+	defer signingCAs.lock.Unlock()
+	signingCAs.phase[signerName] = phase
+}
+
+var signingCAs = &signingCACollector{
+	notAfter: map[string]func() time.Time{},
+	phase:    map[string]func() string{},
+	now:      time.Now,
+}
+
+type signingCACollector struct {
+	metrics.BaseStableCollector
+
+	lock     sync.RWMutex
+	notAfter map[string]func() time.Time
+	phase    map[string]func() string
+	now      func() time.Time
+}
+
+func (c *signingCACollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- caExpirationDesc
+	ch <- caRotationPhaseDesc
+}
+
+func (c *signingCACollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := c.now()
+	for signerName, notAfter := range c.notAfter {
+		ch <- metrics.NewLazyConstMetric(caExpirationDesc, metrics.GaugeValue, notAfter().Sub(now).Seconds(), signerName)
+	}
+	for signerName, phase := range c.phase {
+		ch <- metrics.NewLazyConstMetric(caRotationPhaseDesc, metrics.GaugeValue, 1, signerName, phase())
+	}
+}
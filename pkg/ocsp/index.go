@@ -0,0 +1,93 @@
+package ocsp
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// missesPerSecond and missBurst bound the store lookups of the serials missing from the index.
+	missesPerSecond = 10
+	missBurst       = 20
+)
+
+// errTooManyMisses is returned instead of looking up a serial missing from the index once the
+// lookups exceed their rate, the client is asked to try again later.
+var errTooManyMisses = errors.New("too many lookups of serials missing from the OCSP index")
+
+// index is an in-memory copy of the inventory keyed by serial. Every replica reloads it from the
+// store periodically, so that requests are answered without reading the store and the revocations
+// recorded by the leader or by the revoke command are seen by every replica. A serial missing from
+// the index, e.g. of a certificate issued since the last reload, is looked up in the store at a
+// bounded rate.
+type index struct {
+	store  inventory.Store
+	misses *rate.Limiter
+
+	lock    sync.RWMutex
+	records map[string]*inventory.Record
+}
+
+func newIndex(store inventory.Store) *index {
+	return &index{
+		store:   store,
+		misses:  rate.NewLimiter(missesPerSecond, missBurst),
+		records: map[string]*inventory.Record{},
+	}
+}
+
+// get returns the record of the certificate with the given serial.
+func (i *index) get(ctx context.Context, serial string) (*inventory.Record, error) {
+	i.lock.RLock()
+	record, ok := i.records[serial]
+	i.lock.RUnlock()
+	if ok {
+		return record, nil
+	}
+
+	if !i.misses.Allow() {
+		return nil, errTooManyMisses
+	}
+	record, err := i.store.Get(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	i.set(record)
+	return record, nil
+}
+
+// set replaces the record of a certificate.
+func (i *index) set(record *inventory.Record) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.records[record.Serial] = record
+}
+
+// resync reloads the index from the store. It returns whether a certificate was revoked or dropped
+// from the store since the last reload.
+func (i *index) resync(ctx context.Context) (bool, error) {
+	all, err := i.store.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	records := make(map[string]*inventory.Record, len(all))
+	for _, record := range all {
+		records[record.Serial] = record
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	changed := false
+	for serial, old := range i.records {
+		if record, ok := records[serial]; !ok || (record.RevokedAt == nil) != (old.RevokedAt == nil) {
+			changed = true
+			break
+		}
+	}
+	i.records = records
+	return changed, nil
+}
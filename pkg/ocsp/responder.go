@@ -0,0 +1,424 @@
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	"golang.org/x/crypto/ocsp"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxRequestSize bounds the body of POST requests, a request for a single certificate is about 100 bytes.
+	maxRequestSize = 64 << 10
+	// maxCachedResponses bounds the response cache, responses are no longer cached once it is full.
+	maxCachedResponses = 10000
+)
+
+var (
+	serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+	// oidNoCheck is the id-pkix-ocsp-nocheck extension of RFC 6960 section 4.2.2.2.1
+	oidNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+	// oidNonce is the id-pkix-ocsp-nonce extension of RFC 6960 section 4.4.1
+	oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+)
+
+// maxNonceSize is the largest nonce accepted, RFC 8954 section 2.1.
+const maxNonceSize = 32
+
+// Responder is an RFC 6960 OCSP responder answering with the status of the certificates recorded in
+// the inventory. Responses are signed by the CA that issued the certificates, or by a delegated OCSP
+// signing certificate the responder issues from that CA, and cached.
+type Responder struct {
+	index   *index
+	signers []signer.RevocationSigner
+
+	delegated    bool
+	certDuration time.Duration
+	ttl          time.Duration
+	resyncPeriod time.Duration
+
+	lock sync.Mutex
+	// responses are the cached responses by issuer, hash algorithm and serial number
+	responses map[string]*cachedResponse
+	// responders are the delegated responders by SHA-256 fingerprint of their issuer
+	responders map[string]*delegatedResponder
+}
+
+type cachedResponse struct {
+	der     []byte
+	expires time.Time
+}
+
+type delegatedResponder struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+	// renew is when the certificate is replaced, after two thirds of its validity
+	renew time.Time
+}
+
+// NewResponder returns a responder for the certificates issued by signers and recorded in store.
+func NewResponder(store inventory.Store, signers map[string]signer.Signer, opts options.OCSPOptions) *Responder {
+	r := &Responder{
+		index:        newIndex(store),
+		delegated:    opts.DelegatedResponder,
+		certDuration: opts.ResponderCertificateDuration,
+		ttl:          opts.ResponseTTL,
+		resyncPeriod: opts.ResyncPeriod,
+		responses:    map[string]*cachedResponse{},
+		responders:   map[string]*delegatedResponder{},
+	}
+	names := make([]string, 0, len(signers))
+	for name := range signers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// the certificates of a signer without access to its CA keys are unknown
+		if s, ok := signers[name].(signer.RevocationSigner); ok {
+			r.signers = append(r.signers, s)
+		}
+	}
+	return r
+}
+
+// Run reloads the inventory every resync period until ctx is done, it is run on every replica.
+func (r *Responder) Run(ctx context.Context) {
+	wait.UntilWithContext(ctx, r.resync, r.resyncPeriod)
+}
+
+// resync reloads the inventory, dropping the cached responses when a certificate was revoked.
+func (r *Responder) resync(ctx context.Context) {
+	changed, err := r.index.resync(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Unable to load the inventory for the OCSP responder")
+		return
+	}
+	if changed {
+		r.purge()
+	}
+}
+
+// Update replaces the record of a certificate, so that e.g. its revocation is reflected by the next
+// response without waiting for the next reload of the inventory.
+func (r *Responder) Update(record *inventory.Record) {
+	r.index.set(record)
+	r.purge()
+}
+
+// purge drops the cached responses.
+func (r *Responder) purge() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.responses = map[string]*cachedResponse{}
+}
+
+// ServeHTTP answers OCSP requests POSTed to /ocsp or base64-encoded in the path of a GET, /ocsp/<request>.
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	switch req.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxRequestSize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxRequestSize {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		der = body
+	case http.MethodGet:
+		// the base64 alphabet includes '/' and '+', which the client may have percent-encoded
+		encoded, _ := strings.CutPrefix(req.URL.EscapedPath(), "/ocsp/")
+		unescaped, err := url.PathUnescape(encoded)
+		if err == nil {
+			der, err = base64.StdEncoding.DecodeString(unescaped)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(ocsp.MalformedRequestErrorResponse)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response, expires := r.respond(req.Context(), der)
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	// RFC 5019 section 6: let HTTP caches serve GET responses until they are refreshed
+	if req.Method == http.MethodGet && !expires.IsZero() {
+		maxAge := int(time.Until(expires).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(maxAge)+", public, no-transform, must-revalidate")
+	}
+	w.Write(response)
+}
+
+// respond returns the DER-encoded response to the DER-encoded request der. The expiry is set when
+// the response can be cached, it is zero for unsuccessful responses and for the responses to
+// requests carrying a nonce, which are signed for every request. Like RFC 5019, only the first
+// certificate of a request is answered.
+func (r *Responder) respond(ctx context.Context, der []byte) ([]byte, time.Time) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		klog.V(4).InfoS("Malformed OCSP request", "err", err)
+		return ocsp.MalformedRequestErrorResponse, time.Time{}
+	}
+	nonce, err := requestNonce(der)
+	if err != nil {
+		klog.V(4).InfoS("Malformed OCSP request nonce", "err", err)
+		return ocsp.MalformedRequestErrorResponse, time.Time{}
+	}
+	issuer, issuerKey := r.findIssuer(req.HashAlgorithm, req.IssuerNameHash, req.IssuerKeyHash)
+	if issuer == nil {
+		return ocsp.UnauthorizedErrorResponse, time.Time{}
+	}
+	fingerprint := sha256.Sum256(issuer.Raw)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	cacheKey := fmt.Sprintf("%x/%d/%s", fingerprint, req.HashAlgorithm, inventory.FormatSerial(req.SerialNumber))
+	if nonce == nil {
+		if der, expires := r.cached(cacheKey, now); der != nil {
+			return der, expires
+		}
+	}
+
+	template, err := r.template(ctx, req, fingerprint[:], now)
+	if errors.Is(err, errTooManyMisses) {
+		klog.V(2).InfoS("Deferring OCSP request", "serial", inventory.FormatSerial(req.SerialNumber), "err", err)
+		return ocsp.TryLaterErrorResponse, time.Time{}
+	}
+	if err != nil {
+		klog.ErrorS(err, "Unable to look up certificate status", "serial", inventory.FormatSerial(req.SerialNumber))
+		return ocsp.TryLaterErrorResponse, time.Time{}
+	}
+	if nonce != nil {
+		// x/crypto/ocsp only copies extensions into the single response, where Go clients read it
+		template.ExtraExtensions = []pkix.Extension{*nonce}
+	}
+
+	responderCert, key := issuer, issuerKey
+	if r.delegated {
+		responder, err := r.delegatedResponder(issuer, issuerKey, now)
+		if err != nil {
+			klog.ErrorS(err, "Unable to issue delegated OCSP responder certificate", "issuer", issuer.Subject.String())
+			return ocsp.InternalErrorErrorResponse, time.Time{}
+		}
+		responderCert, key = responder.certificate, responder.key
+		// the client verifies the delegation with the certificate embedded in the response
+		template.Certificate = responder.certificate
+	}
+
+	response, err := ocsp.CreateResponse(issuer, responderCert, template, key)
+	if err != nil {
+		klog.ErrorS(err, "Unable to sign OCSP response", "issuer", issuer.Subject.String())
+		return ocsp.InternalErrorErrorResponse, time.Time{}
+	}
+	if nonce != nil {
+		return response, time.Time{}
+	}
+	expires := now.Add(r.ttl)
+	r.cache(cacheKey, response, expires, now)
+	return response, expires
+}
+
+// requestNonce returns the nonce extension of the DER-encoded request der, nil if it has none.
+// ocsp.ParseRequest ignores the request extensions.
+func requestNonce(der []byte) (*pkix.Extension, error) {
+	var request struct {
+		TBSRequest struct {
+			Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+			RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+			RequestList       []asn1.RawValue
+			RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+		}
+		OptionalSignature asn1.RawValue `asn1:"explicit,tag:0,optional"`
+	}
+	if _, err := asn1.Unmarshal(der, &request); err != nil {
+		return nil, err
+	}
+	for _, ext := range request.TBSRequest.RequestExtensions {
+		if !ext.Id.Equal(oidNonce) {
+			continue
+		}
+		var nonce []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &nonce); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("nonce is not an octet string")
+		}
+		if len(nonce) == 0 || len(nonce) > maxNonceSize {
+			return nil, fmt.Errorf("nonce of %d bytes, it must be 1 to %d bytes long", len(nonce), maxNonceSize)
+		}
+		return &pkix.Extension{Id: oidNonce, Value: ext.Value}, nil
+	}
+	return nil, nil
+}
+
+// findIssuer returns the CA with a private key whose subject and public key hash to nameHash and keyHash.
+func (r *Responder) findIssuer(hash crypto.Hash, nameHash, keyHash []byte) (*x509.Certificate, crypto.Signer) {
+	match := func(ca *x509.Certificate) bool {
+		if !bytes.Equal(digest(hash, ca.RawSubject), nameHash) {
+			return false
+		}
+		publicKey, err := subjectPublicKey(ca)
+		return err == nil && bytes.Equal(digest(hash, publicKey), keyHash)
+	}
+	for _, s := range r.signers {
+		if ca, key := s.FindCA(match); ca != nil {
+			return ca, key
+		}
+	}
+	return nil, nil
+}
+
+// template returns the response describing the status of the certificate of req. A certificate
+// missing from the inventory, or recorded as issued by another CA, is unknown.
+func (r *Responder) template(ctx context.Context, req *ocsp.Request, issuerFingerprint []byte, now time.Time) (ocsp.Response, error) {
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		IssuerHash:   req.HashAlgorithm,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(r.ttl),
+	}
+	if req.SerialNumber.Sign() <= 0 {
+		return template, nil
+	}
+	record, err := r.index.get(ctx, inventory.FormatSerial(req.SerialNumber))
+	switch {
+	case errors.Is(err, inventory.ErrNotFound):
+	case err != nil:
+		return template, err
+	case record.CAFingerprint != fmt.Sprintf("%x", issuerFingerprint):
+	case record.RevokedAt != nil:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = record.RevokedAt.UTC()
+		template.RevocationReason = inventory.RevocationReasons[record.RevocationReason]
+	default:
+		template.Status = ocsp.Good
+	}
+	return template, nil
+}
+
+// delegatedResponder returns the delegated responder of issuer, issuing a new certificate when it is due for renewal.
+func (r *Responder) delegatedResponder(issuer *x509.Certificate, issuerKey crypto.Signer, now time.Time) (*delegatedResponder, error) {
+	fingerprint := sha256.Sum256(issuer.Raw)
+	id := fmt.Sprintf("%x", fingerprint)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if responder, ok := r.responders[id]; ok && now.Before(responder.renew) {
+		return responder, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	notBefore := now.Add(-5 * time.Minute)
+	notAfter := now.Add(r.certDuration)
+	if notAfter.After(issuer.NotAfter) {
+		notAfter = issuer.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: issuer.Subject.CommonName + " OCSP responder"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		// RFC 6960 section 4.2.2.2.1: clients need not check the revocation status of the responder
+		ExtraExtensions: []pkix.Extension{{Id: oidNoCheck, Value: asn1.NullBytes}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, key.Public(), issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	responder := &delegatedResponder{
+		certificate: cert,
+		key:         key,
+		renew:       now.Add(notAfter.Sub(now) * 2 / 3),
+	}
+	r.responders[id] = responder
+	klog.InfoS("Issued delegated OCSP responder certificate", "issuer", issuer.Subject.String(), "notAfter", notAfter)
+	return responder, nil
+}
+
+func (r *Responder) cached(key string, now time.Time) ([]byte, time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	cached, ok := r.responses[key]
+	if !ok || !now.Before(cached.expires) {
+		return nil, time.Time{}
+	}
+	return cached.der, cached.expires
+}
+
+func (r *Responder) cache(key string, der []byte, expires, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.responses) >= maxCachedResponses {
+		for k, cached := range r.responses {
+			if !now.Before(cached.expires) {
+				delete(r.responses, k)
+			}
+		}
+		if len(r.responses) >= maxCachedResponses {
+			return
+		}
+	}
+	r.responses[key] = &cachedResponse{der: der, expires: expires}
+}
+
+// subjectPublicKey returns the subjectPublicKey bit string of cert, excluding its algorithm identifier.
+func subjectPublicKey(cert *x509.Certificate) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	return spki.PublicKey.RightAlign(), nil
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,357 @@
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/inventory"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"github.com/ericpuwang/certificate-controller/pkg/signer"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testSigner is a revocation signer holding a single CA.
+type testSigner struct {
+	signer.Signer
+	ca  *x509.Certificate
+	key crypto.Signer
+}
+
+func (s *testSigner) FindCA(match func(*x509.Certificate) bool) (*x509.Certificate, crypto.Signer) {
+	if match(s.ca) {
+		return s.ca, s.key
+	}
+	return nil, nil
+}
+
+func newCertificate(t *testing.T, serial int64, cn string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResponder(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newCertificate(t, 1, "test CA", nil, nil)
+	otherCA, otherKey := newCertificate(t, 2, "other CA", nil, nil)
+
+	good, _ := newCertificate(t, 0x1001, "good", ca, caKey)
+	revoked, _ := newCertificate(t, 0x1002, "revoked", ca, caKey)
+	missing, _ := newCertificate(t, 0x1003, "missing", ca, caKey)
+	// a certificate with the serial of good issued by a CA none of the signers holds
+	foreign, _ := newCertificate(t, 0x1001, "foreign", otherCA, otherKey)
+
+	store, err := inventory.NewFileStore(filepath.Join(t.TempDir(), "inventory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cert := range []*x509.Certificate{good, revoked} {
+		record := &inventory.Record{
+			Serial:        inventory.FormatSerial(cert.SerialNumber),
+			Subject:       cert.Subject.String(),
+			NotBefore:     cert.NotBefore,
+			NotAfter:      cert.NotAfter,
+			SignerName:    "example.com/test",
+			CAFingerprint: fingerprint(ca),
+		}
+		if err := store.Add(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	if _, _, err := inventory.Revoke(ctx, store, inventory.FormatSerial(revoked.SerialNumber), "keyCompromise", revokedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]signer.Signer{
+		"example.com/test": &testSigner{ca: ca, key: caKey},
+	}
+	for _, delegated := range []bool{false, true} {
+		opts := options.NewOCSPOptions()
+		opts.Enabled = true
+		opts.DelegatedResponder = delegated
+		r := NewResponder(store, signers, opts)
+
+		for _, tc := range []struct {
+			name   string
+			cert   *x509.Certificate
+			issuer *x509.Certificate
+			status int
+		}{
+			{name: "good", cert: good, issuer: ca, status: ocsp.Good},
+			{name: "revoked", cert: revoked, issuer: ca, status: ocsp.Revoked},
+			{name: "unknown serial", cert: missing, issuer: ca, status: ocsp.Unknown},
+		} {
+			for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+				request, err := ocsp.CreateRequest(tc.cert, tc.issuer, &ocsp.RequestOptions{Hash: hash})
+				if err != nil {
+					t.Fatal(err)
+				}
+				der := post(t, r, request)
+				response, err := ocsp.ParseResponseForCert(der, tc.cert, tc.issuer)
+				if err != nil {
+					t.Fatalf("delegated=%v %s %v: %v", delegated, tc.name, hash, err)
+				}
+				if response.Status != tc.status {
+					t.Errorf("delegated=%v %s %v: status %d, expected %d", delegated, tc.name, hash, response.Status, tc.status)
+				}
+				if delegated != (response.Certificate != nil) {
+					t.Errorf("delegated=%v %s %v: responder certificate %v", delegated, tc.name, hash, response.Certificate)
+				}
+				if delegated && response.Certificate != nil && !bytes.Equal(response.Certificate.RawIssuer, ca.RawSubject) {
+					t.Errorf("delegated=%v %s %v: responder certificate issued by %s", delegated, tc.name, hash, response.Certificate.Issuer)
+				}
+				if tc.status == ocsp.Revoked && (!response.RevokedAt.Equal(revokedAt) || response.RevocationReason != ocsp.KeyCompromise) {
+					t.Errorf("delegated=%v %s %v: revoked at %v for %d", delegated, tc.name, hash, response.RevokedAt, response.RevocationReason)
+				}
+			}
+		}
+
+		// the issuer of foreign is no CA of the signers
+		request, err := ocsp.CreateRequest(foreign, otherCA, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if der := post(t, r, request); !bytes.Equal(der, ocsp.UnauthorizedErrorResponse) {
+			t.Errorf("delegated=%v: expected unauthorized response for another issuer, got %x", delegated, der)
+		}
+		if der := post(t, r, []byte("not a request")); !bytes.Equal(der, ocsp.MalformedRequestErrorResponse) {
+			t.Errorf("delegated=%v: expected malformed request response, got %x", delegated, der)
+		}
+	}
+}
+
+func post(t *testing.T, r http.Handler, request []byte) []byte {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader(request)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	return w.Body.Bytes()
+}
+
+func TestResponderGet(t *testing.T) {
+	ca, caKey := newCertificate(t, 1, "test CA", nil, nil)
+	store, err := inventory.NewFileStore(filepath.Join(t.TempDir(), "inventory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResponder(store, map[string]signer.Signer{"example.com/test": &testSigner{ca: ca, key: caKey}}, options.NewOCSPOptions())
+
+	leaf, _ := newCertificate(t, 0x1001, "leaf", ca, caKey)
+	request, err := ocsp.CreateRequest(leaf, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(request)
+
+	for _, path := range []string{
+		"/ocsp/" + encoded,
+		"/ocsp/" + url.QueryEscape(encoded),
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		response, err := ocsp.ParseResponseForCert(w.Body.Bytes(), leaf, ca)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if response.Status != ocsp.Unknown || response.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			t.Errorf("GET %s: status %d for serial %v", path, response.Status, response.SerialNumber)
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "max-age=") {
+			t.Errorf("GET %s: unexpected Cache-Control %q", path, cacheControl)
+		}
+	}
+}
+
+// withNonce returns request with a nonce extension holding nonce.
+func withNonce(t *testing.T, request, nonce []byte) []byte {
+	t.Helper()
+	var decoded struct {
+		TBSRequest struct {
+			Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+			RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+			RequestList       []asn1.RawValue
+			RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+		}
+	}
+	if _, err := asn1.Unmarshal(request, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded.TBSRequest.RequestExtensions = append(decoded.TBSRequest.RequestExtensions, pkix.Extension{Id: oidNonce, Value: value})
+	der, err := asn1.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestResponderNonce(t *testing.T) {
+	ca, caKey := newCertificate(t, 1, "test CA", nil, nil)
+	store, err := inventory.NewFileStore(filepath.Join(t.TempDir(), "inventory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResponder(store, map[string]signer.Signer{"example.com/test": &testSigner{ca: ca, key: caKey}}, options.NewOCSPOptions())
+
+	leaf, _ := newCertificate(t, 0x1001, "leaf", ca, caKey)
+	request, err := ocsp.CreateRequest(leaf, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a response without nonce is cached first, the requests with a nonce must not be answered from the cache
+	post(t, r, request)
+	for _, nonce := range [][]byte{[]byte("first nonce"), []byte("second nonce")} {
+		response, err := ocsp.ParseResponseForCert(post(t, r, withNonce(t, request, nonce)), leaf, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echoed := false
+		for _, ext := range response.Extensions {
+			var value []byte
+			if ext.Id.Equal(oidNonce) {
+				_, err := asn1.Unmarshal(ext.Value, &value)
+				echoed = err == nil && bytes.Equal(value, nonce)
+			}
+		}
+		if !echoed {
+			t.Errorf("nonce %q not echoed in %v", nonce, response.Extensions)
+		}
+	}
+
+	if der := post(t, r, withNonce(t, request, bytes.Repeat([]byte{1}, maxNonceSize+1))); !bytes.Equal(der, ocsp.MalformedRequestErrorResponse) {
+		t.Errorf("expected malformed request response for an oversized nonce, got %x", der)
+	}
+
+	// the response to a GET request with a nonce is not cacheable either
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ocsp/"+url.PathEscape(base64.StdEncoding.EncodeToString(withNonce(t, request, []byte("get nonce")))), nil))
+	if cacheControl := w.Header().Get("Cache-Control"); len(cacheControl) > 0 {
+		t.Errorf("unexpected Cache-Control %q for a request with a nonce", cacheControl)
+	}
+}
+
+func TestResponderResync(t *testing.T) {
+	ctx := context.Background()
+	ca, caKey := newCertificate(t, 1, "test CA", nil, nil)
+	leaf, _ := newCertificate(t, 0x1001, "leaf", ca, caKey)
+	store, err := inventory.NewFileStore(filepath.Join(t.TempDir(), "inventory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &inventory.Record{
+		Serial:        inventory.FormatSerial(leaf.SerialNumber),
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		CAFingerprint: fingerprint(ca),
+	}
+	if err := store.Add(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	r := NewResponder(store, map[string]signer.Signer{"example.com/test": &testSigner{ca: ca, key: caKey}}, options.NewOCSPOptions())
+	r.resync(ctx)
+
+	request, err := ocsp.CreateRequest(leaf, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func() int {
+		response, err := ocsp.ParseResponseForCert(post(t, r, request), leaf, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Status
+	}
+	if s := status(); s != ocsp.Good {
+		t.Fatalf("status %d, expected good", s)
+	}
+
+	// revoked by another replica or by the revoke command, the cached response is served until the next reload
+	if _, _, err := inventory.Revoke(ctx, store, record.Serial, "superseded", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s != ocsp.Good {
+		t.Fatalf("status %d before reload, expected good", s)
+	}
+	r.resync(ctx)
+	if s := status(); s != ocsp.Revoked {
+		t.Fatalf("status %d after reload, expected revoked", s)
+	}
+}
+
+func TestResponderMisses(t *testing.T) {
+	ca, caKey := newCertificate(t, 1, "test CA", nil, nil)
+	store, err := inventory.NewFileStore(filepath.Join(t.TempDir(), "inventory"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResponder(store, map[string]signer.Signer{"example.com/test": &testSigner{ca: ca, key: caKey}}, options.NewOCSPOptions())
+
+	leaf, _ := newCertificate(t, 1, "leaf", ca, caKey)
+	tryLater := 0
+	for serial := int64(1); serial <= 2*missBurst; serial++ {
+		leaf.SerialNumber = big.NewInt(serial)
+		request, err := ocsp.CreateRequest(leaf, ca, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(post(t, r, request), ocsp.TryLaterErrorResponse) {
+			tryLater++
+		}
+	}
+	// the lookups are refilled at missesPerSecond while the requests are answered
+	if tryLater == 0 || tryLater > missBurst {
+		t.Errorf("%d of %d requests for unknown serials deferred", tryLater, 2*missBurst)
+	}
+}
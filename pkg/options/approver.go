@@ -0,0 +1,60 @@
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ApproverOptions configures the optional approver of certificate signing requests.
+type ApproverOptions struct {
+	Enabled     bool
+	SignerNames []string
+	Verb        string
+	Group       string
+	Resource    string
+}
+
+func NewApproverOptions() ApproverOptions {
+	return ApproverOptions{
+		SignerNames: []string{AppServingSignerName},
+		Verb:        "create",
+		Group:       "certificates.k8s.io",
+		Resource:    "certificatesigningrequests/appserving",
+	}
+}
+
+func (o *ApproverOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "auto-approve", o.Enabled, "Approve certificate signing requests whose requester is authorized by a SubjectAccessReview and whose request passes the signing policy, and deny the others")
+	fs.StringSliceVar(&o.SignerNames, "auto-approve-signer-names", o.SignerNames, "Signer names whose certificate signing requests are approved or denied automatically")
+	fs.StringVar(&o.Verb, "auto-approve-verb", o.Verb, "Verb of the SubjectAccessReview the requester must pass")
+	fs.StringVar(&o.Group, "auto-approve-group", o.Group, "API group of the SubjectAccessReview the requester must pass")
+	fs.StringVar(&o.Resource, "auto-approve-resource", o.Resource, "Resource, optionally followed by /<subresource>, of the SubjectAccessReview the requester must pass. The signer name is used as the resource name")
+}
+
+func (o *ApproverOptions) Validate(signers []SignerConfiguration) []error {
+	if !o.Enabled {
+		return nil
+	}
+	var allErrs []error
+	configured := sets.New[string]()
+	for _, s := range signers {
+		configured.Insert(s.Name)
+	}
+	if len(o.SignerNames) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--auto-approve-signer-names must not be empty"))
+	}
+	for _, name := range o.SignerNames {
+		if !configured.Has(name) {
+			allErrs = append(allErrs, fmt.Errorf("--auto-approve-signer-names: signer %q is not configured", name))
+		}
+	}
+	if len(o.Verb) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--auto-approve-verb is required"))
+	}
+	if len(o.Resource) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--auto-approve-resource is required"))
+	}
+	return allErrs
+}
@@ -0,0 +1,52 @@
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// CRLOptions configures the certificate revocation lists published by the controller.
+type CRLOptions struct {
+	Enabled            bool
+	UpdatePeriod       time.Duration
+	ConfigMapNamespace string
+	ConfigMapName      string
+}
+
+func NewCRLOptions() CRLOptions {
+	return CRLOptions{
+		UpdatePeriod:       15 * time.Minute,
+		ConfigMapNamespace: "kube-system",
+		ConfigMapName:      "certificate-controller-crl",
+	}
+}
+
+func (o *CRLOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "crl", o.Enabled, "Publish per signer a certificate revocation list of the revoked certificates of the inventory, signed by the active CA. It is served at /crl/<signer name> and written to --crl-configmap-name. Requires --inventory-backend")
+	fs.DurationVar(&o.UpdatePeriod, "crl-update-period", o.UpdatePeriod, "How often the certificate revocation lists are regenerated. Their nextUpdate is twice this period")
+	fs.StringVar(&o.ConfigMapNamespace, "crl-configmap-namespace", o.ConfigMapNamespace, "The namespace of the ConfigMap holding the certificate revocation lists")
+	fs.StringVar(&o.ConfigMapName, "crl-configmap-name", o.ConfigMapName, "The name of the ConfigMap holding the PEM-encoded certificate revocation list of every signer under the key <signer name with / replaced by _>.crl")
+}
+
+func (o *CRLOptions) Validate(inventory *InventoryOptions) []error {
+	if !o.Enabled {
+		return nil
+	}
+	var allErrs []error
+	if len(inventory.Backend) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--crl requires --inventory-backend"))
+	}
+	if o.UpdatePeriod <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--crl-update-period must be greater than zero"))
+	}
+	for _, msg := range validation.IsDNS1123Label(o.ConfigMapNamespace) {
+		allErrs = append(allErrs, fmt.Errorf("--crl-configmap-namespace: %q: %s", o.ConfigMapNamespace, msg))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(o.ConfigMapName) {
+		allErrs = append(allErrs, fmt.Errorf("--crl-configmap-name: %q: %s", o.ConfigMapName, msg))
+	}
+	return allErrs
+}
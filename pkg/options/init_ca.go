@@ -0,0 +1,211 @@
+package options
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/pki"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/cli/flag"
+)
+
+// InitCAOptions configures the init-ca subcommand, generating a self-signed root CA or the
+// certificate signing request of an intermediate CA.
+type InitCAOptions struct {
+	KubeConfig string
+
+	CommonName         string
+	Organization       []string
+	OrganizationalUnit []string
+	Country            []string
+	Province           []string
+	Locality           []string
+
+	KeyAlgorithm string
+	KeySize      int
+	Duration     time.Duration
+	PathLength   int
+	Intermediate bool
+
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+	PermittedIPRanges   []string
+	ExcludedIPRanges    []string
+
+	CertFile string
+	KeyFile  string
+	CSRFile  string
+	Secret   string
+}
+
+func NewInitCAOptions() *InitCAOptions {
+	return &InitCAOptions{
+		KeyAlgorithm: pki.KeyAlgorithmECDSA,
+		Duration:     10 * 365 * 24 * time.Hour,
+		CertFile:     "ca.crt",
+		KeyFile:      "ca.key",
+		CSRFile:      "ca.csr",
+	}
+}
+
+func (o *InitCAOptions) Validate() error {
+	var allErrs []error
+	if len(o.CommonName) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--common-name is required"))
+	}
+	allErrs = append(allErrs, validateKeyAlgorithm(o.KeyAlgorithm, o.KeySize)...)
+	if o.Duration <= 0 && !o.Intermediate {
+		allErrs = append(allErrs, fmt.Errorf("--duration must be greater than zero"))
+	}
+	if o.PathLength < -1 {
+		allErrs = append(allErrs, fmt.Errorf("--path-length must be -1 or greater"))
+	}
+	allErrs = append(allErrs, validateDNSDomains("--permitted-dns-domains", o.PermittedDNSDomains)...)
+	allErrs = append(allErrs, validateDNSDomains("--excluded-dns-domains", o.ExcludedDNSDomains)...)
+	if _, err := parseCIDRs(o.PermittedIPRanges); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("--permitted-ip-ranges: %v", err))
+	}
+	if _, err := parseCIDRs(o.ExcludedIPRanges); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("--excluded-ip-ranges: %v", err))
+	}
+	if len(o.Secret) > 0 {
+		if o.Intermediate {
+			allErrs = append(allErrs, fmt.Errorf("--secret is mutually exclusive with --intermediate, the certificate of an intermediate CA is issued by its root"))
+		}
+		allErrs = append(allErrs, validateSecretReference("--secret", o.Secret)...)
+	}
+	return utilerrors.NewAggregate(allErrs)
+}
+
+func (o *InitCAOptions) Flags() flag.NamedFlagSets {
+	fss := flag.NamedFlagSets{}
+	pflag := fss.FlagSet("global")
+	pflag.StringVar(&o.KubeConfig, "kubeconfig", o.KubeConfig, "path to the kubeconfig file to use for apiserver proxy")
+
+	subject := fss.FlagSet("subject")
+	subject.StringVar(&o.CommonName, "common-name", o.CommonName, "The common name of the CA, e.g. app-serving-ca")
+	subject.StringSliceVar(&o.Organization, "organization", o.Organization, "The organizations of the subject of the CA")
+	subject.StringSliceVar(&o.OrganizationalUnit, "organizational-unit", o.OrganizationalUnit, "The organizational units of the subject of the CA")
+	subject.StringSliceVar(&o.Country, "country", o.Country, "The countries of the subject of the CA")
+	subject.StringSliceVar(&o.Province, "province", o.Province, "The provinces of the subject of the CA")
+	subject.StringSliceVar(&o.Locality, "locality", o.Locality, "The localities of the subject of the CA")
+
+	ca := fss.FlagSet("ca")
+	ca.StringVar(&o.KeyAlgorithm, "key-algorithm", o.KeyAlgorithm, "The algorithm of the CA key: RSA, ECDSA or Ed25519")
+	ca.IntVar(&o.KeySize, "key-size", o.KeySize, "The size of the CA key, 2048 to 4096 bits for RSA and 256 or 384 for ECDSA. 0 selects 3072 for RSA and 256 for ECDSA")
+	ca.DurationVar(&o.Duration, "duration", o.Duration, "The validity of the self-signed CA, it bounds the lifetime of every certificate it issues")
+	ca.IntVar(&o.PathLength, "path-length", o.PathLength, "The number of intermediate CAs allowed below the CA, -1 for no limit. 0 is enough for a CA issuing the certificates of the controller")
+	ca.BoolVar(&o.Intermediate, "intermediate", o.Intermediate, "Generate the certificate signing request of an intermediate CA written to --csr-file instead of a self-signed CA")
+	ca.StringSliceVar(&o.PermittedDNSDomains, "permitted-dns-domains", o.PermittedDNSDomains, "Name constraints: the DNS domains the certificates issued by the CA are restricted to, e.g. svc.cluster.local. A leading dot restricts them to the subdomains")
+	ca.StringSliceVar(&o.ExcludedDNSDomains, "excluded-dns-domains", o.ExcludedDNSDomains, "Name constraints: the DNS domains the certificates issued by the CA may not contain")
+	ca.StringSliceVar(&o.PermittedIPRanges, "permitted-ip-ranges", o.PermittedIPRanges, "Name constraints: the CIDRs the IP addresses of the certificates issued by the CA are restricted to")
+	ca.StringSliceVar(&o.ExcludedIPRanges, "excluded-ip-ranges", o.ExcludedIPRanges, "Name constraints: the CIDRs the IP addresses of the certificates issued by the CA may not be in")
+
+	output := fss.FlagSet("output")
+	output.StringVar(&o.CertFile, "cert-file", o.CertFile, "The file the PEM-encoded self-signed CA certificate is written to, ready for --signing-cert-file")
+	output.StringVar(&o.KeyFile, "key-file", o.KeyFile, "The file the PEM-encoded CA key is written to, ready for --signing-key-file")
+	output.StringVar(&o.CSRFile, "csr-file", o.CSRFile, "The file the PEM-encoded certificate signing request of an intermediate CA is written to")
+	output.StringVar(&o.Secret, "secret", o.Secret, "The <namespace>/<name> of a kubernetes.io/tls Secret created with the self-signed CA instead of files, ready for --signing-secret")
+
+	return fss
+}
+
+// ClientConfig returns the rest config built from --kubeconfig, or the in-cluster config if it is not set.
+func (o *InitCAOptions) ClientConfig() (*rest.Config, error) {
+	if o.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", o.KubeConfig)
+}
+
+// Template returns the CA described by the flags.
+func (o *InitCAOptions) Template() (pki.CATemplate, error) {
+	t := pki.CATemplate{
+		Duration:            o.Duration,
+		MaxPathLen:          o.PathLength,
+		PermittedDNSDomains: o.PermittedDNSDomains,
+		ExcludedDNSDomains:  o.ExcludedDNSDomains,
+	}
+	t.Subject.CommonName = o.CommonName
+	t.Subject.Organization = o.Organization
+	t.Subject.OrganizationalUnit = o.OrganizationalUnit
+	t.Subject.Country = o.Country
+	t.Subject.Province = o.Province
+	t.Subject.Locality = o.Locality
+
+	var err error
+	if t.PermittedIPRanges, err = parseCIDRs(o.PermittedIPRanges); err != nil {
+		return t, err
+	}
+	if t.ExcludedIPRanges, err = parseCIDRs(o.ExcludedIPRanges); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// KeySizeOrDefault returns --key-size, defaulted by --key-algorithm.
+func (o *InitCAOptions) KeySizeOrDefault() int {
+	return defaultKeySize(o.KeyAlgorithm, o.KeySize)
+}
+
+func defaultKeySize(algorithm string, size int) int {
+	if size != 0 {
+		return size
+	}
+	switch algorithm {
+	case pki.KeyAlgorithmRSA:
+		return 3072
+	case pki.KeyAlgorithmECDSA:
+		return 256
+	}
+	return 0
+}
+
+func validateKeyAlgorithm(algorithm string, size int) []error {
+	size = defaultKeySize(algorithm, size)
+	switch algorithm {
+	case pki.KeyAlgorithmRSA:
+		if size < 2048 || size > 4096 {
+			return []error{fmt.Errorf("--key-size must be between 2048 and 4096 for RSA keys")}
+		}
+	case pki.KeyAlgorithmECDSA:
+		if size != 256 && size != 384 {
+			return []error{fmt.Errorf("--key-size must be 256 or 384 for ECDSA keys")}
+		}
+	case pki.KeyAlgorithmEd25519:
+		if size != 0 {
+			return []error{fmt.Errorf("--key-size is not supported for Ed25519 keys")}
+		}
+	default:
+		return []error{fmt.Errorf("--key-algorithm must be one of %s, %s and %s", pki.KeyAlgorithmRSA, pki.KeyAlgorithmECDSA, pki.KeyAlgorithmEd25519)}
+	}
+	return nil
+}
+
+// validateDNSDomains checks the domains of name constraints, a leading dot constrains the subdomains only.
+func validateDNSDomains(flagName string, domains []string) []error {
+	var allErrs []error
+	for _, domain := range domains {
+		for _, msg := range validation.IsDNS1123Subdomain(strings.TrimPrefix(domain, ".")) {
+			allErrs = append(allErrs, fmt.Errorf("%s: %q: %s", flagName, domain, msg))
+		}
+	}
+	return allErrs
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
@@ -0,0 +1,75 @@
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// InventoryBackendFile appends the issued certificates to a local file.
+	InventoryBackendFile = "file"
+	// InventoryBackendConfigMap shards the issued certificates across ConfigMaps.
+	InventoryBackendConfigMap = "configmap"
+	// InventoryBackendSecret shards the issued certificates across Secrets.
+	InventoryBackendSecret = "secret"
+)
+
+// InventoryOptions configures the optional inventory recording every issued certificate.
+type InventoryOptions struct {
+	Backend   string
+	File      string
+	Namespace string
+	Name      string
+	Shards    int
+	// Retention is how long the records of expired certificates are kept by the configmap and secret backends.
+	Retention time.Duration
+}
+
+func NewInventoryOptions() InventoryOptions {
+	return InventoryOptions{
+		Namespace: "kube-system",
+		Name:      "certificate-inventory",
+		Shards:    16,
+		Retention: 30 * 24 * time.Hour,
+	}
+}
+
+func (o *InventoryOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Backend, "inventory-backend", o.Backend, "Record every issued certificate in an inventory queryable by serial or subjectAltName. One of file, configmap or secret, empty disables the inventory")
+	fs.StringVar(&o.File, "inventory-file", o.File, "The append-only file of the file inventory backend")
+	fs.StringVar(&o.Namespace, "inventory-namespace", o.Namespace, "The namespace of the ConfigMaps or Secrets of the configmap and secret inventory backends")
+	fs.StringVar(&o.Name, "inventory-name", o.Name, "The name prefix of the ConfigMaps or Secrets of the configmap and secret inventory backends, shards are named <prefix>-<n>")
+	fs.IntVar(&o.Shards, "inventory-shards", o.Shards, "The number of ConfigMaps or Secrets the configmap and secret inventory backends spread the certificates over. Each one holds up to 1MiB")
+	fs.DurationVar(&o.Retention, "inventory-retention", o.Retention, "How long the configmap and secret inventory backends keep the records of expired certificates. They are dropped from a shard when it is written")
+}
+
+func (o *InventoryOptions) Validate() []error {
+	var allErrs []error
+	switch o.Backend {
+	case "":
+	case InventoryBackendFile:
+		if len(o.File) == 0 {
+			allErrs = append(allErrs, fmt.Errorf("--inventory-file is required by the %s inventory backend", o.Backend))
+		}
+	case InventoryBackendConfigMap, InventoryBackendSecret:
+		for _, msg := range validation.IsDNS1123Label(o.Namespace) {
+			allErrs = append(allErrs, fmt.Errorf("--inventory-namespace: %q: %s", o.Namespace, msg))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(fmt.Sprintf("%s-%d", o.Name, o.Shards)) {
+			allErrs = append(allErrs, fmt.Errorf("--inventory-name: %q: %s", o.Name, msg))
+		}
+		if o.Shards <= 0 {
+			allErrs = append(allErrs, fmt.Errorf("--inventory-shards must be greater than zero"))
+		}
+		if o.Retention < 0 {
+			allErrs = append(allErrs, fmt.Errorf("--inventory-retention must not be negative"))
+		}
+	default:
+		allErrs = append(allErrs, fmt.Errorf("--inventory-backend: unknown backend %q, must be one of %s, %s or %s",
+			o.Backend, InventoryBackendFile, InventoryBackendConfigMap, InventoryBackendSecret))
+	}
+	return allErrs
+}
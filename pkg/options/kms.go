@@ -0,0 +1,58 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KMSConfiguration locates a CA private key held by a key service listening on a unix socket,
+// similar to a Kubernetes KMS plugin, instead of a key file.
+type KMSConfiguration struct {
+	// Endpoint is the unix socket of the key service, e.g. unix:///var/run/kms-plugin/socket.sock.
+	Endpoint string `json:"endpoint"`
+	// Timeout bounds every call to the key service, 3s by default.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+func validateKMS(field string, c *KMSConfiguration) []error {
+	var allErrs []error
+	if u, err := url.Parse(c.Endpoint); err != nil || u.Scheme != "unix" || len(u.Path) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.endpoint: %q must be of the form unix:///path/to/socket", field, c.Endpoint))
+	}
+	if c.Timeout.Duration < 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.timeout must not be negative", field))
+	}
+	return allErrs
+}
+
+// KMSOptions locate the key of cms.io/app-serving in a key service instead of --signing-key-file.
+type KMSOptions struct {
+	Endpoint string
+	Timeout  time.Duration
+}
+
+func NewKMSOptions() KMSOptions {
+	return KMSOptions{
+		Timeout: 3 * time.Second,
+	}
+}
+
+func (o *KMSOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, "signing-kms-endpoint", o.Endpoint, "The unix socket of the key service holding the private key of --signing-cert-file, e.g. unix:///var/run/kms-plugin/socket.sock. Mutually exclusive with --signing-key-file and --signing-pkcs11-module")
+	fs.DurationVar(&o.Timeout, "signing-kms-timeout", o.Timeout, "The maximum duration of a call to the key service of --signing-kms-endpoint")
+}
+
+// Configuration returns the key location set by the flags, nil if --signing-kms-endpoint is not set.
+func (o *KMSOptions) Configuration() *KMSConfiguration {
+	if len(o.Endpoint) == 0 {
+		return nil
+	}
+	return &KMSConfiguration{
+		Endpoint: o.Endpoint,
+		Timeout:  metav1.Duration{Duration: o.Timeout},
+	}
+}
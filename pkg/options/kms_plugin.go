@@ -0,0 +1,45 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/component-base/cli/flag"
+)
+
+// KMSPluginOptions configures the kms-plugin subcommand, serving a key file as a key service.
+type KMSPluginOptions struct {
+	Endpoint string
+	KeyFile  string
+	KeyID    string
+}
+
+func NewKMSPluginOptions() *KMSPluginOptions {
+	return &KMSPluginOptions{
+		Endpoint: "unix:///var/run/kms-plugin/socket.sock",
+		KeyID:    "local",
+	}
+}
+
+func (o *KMSPluginOptions) Validate() error {
+	var allErrs []error
+	if len(o.KeyFile) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--key-file is required"))
+	}
+	if u, err := url.Parse(o.Endpoint); err != nil || u.Scheme != "unix" || len(u.Path) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--endpoint: %q must be of the form unix:///path/to/socket", o.Endpoint))
+	}
+	return utilerrors.NewAggregate(allErrs)
+}
+
+func (o *KMSPluginOptions) Flags() flag.NamedFlagSets {
+	fss := flag.NamedFlagSets{}
+	pflag := fss.FlagSet("global")
+
+	pflag.StringVar(&o.Endpoint, "endpoint", o.Endpoint, "The unix socket the key service listens on")
+	pflag.StringVar(&o.KeyFile, "key-file", o.KeyFile, "Filename containing the PEM-encoded private key served by the key service")
+	pflag.StringVar(&o.KeyID, "key-id", o.KeyID, "The identifier of the key reported by the key service")
+
+	return fss
+}
@@ -0,0 +1,58 @@
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// OCSPOptions configures the OCSP responder of the controller.
+type OCSPOptions struct {
+	Enabled                      bool
+	DelegatedResponder           bool
+	ResponderCertificateDuration time.Duration
+	ResponseTTL                  time.Duration
+	ResyncPeriod                 time.Duration
+}
+
+func NewOCSPOptions() OCSPOptions {
+	return OCSPOptions{
+		ResponderCertificateDuration: 24 * time.Hour,
+		ResponseTTL:                  5 * time.Minute,
+		ResyncPeriod:                 time.Minute,
+	}
+}
+
+func (o *OCSPOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "ocsp", o.Enabled, "Serve an RFC 6960 OCSP responder at /ocsp answering with the status of the certificates of the inventory. Requires --inventory-backend")
+	fs.BoolVar(&o.DelegatedResponder, "ocsp-delegated-responder", o.DelegatedResponder, "Sign OCSP responses with a short-lived OCSP signing certificate issued by the CA instead of the CA key itself")
+	fs.DurationVar(&o.ResponderCertificateDuration, "ocsp-responder-certificate-duration", o.ResponderCertificateDuration, "The validity of the delegated OCSP signing certificates, they are renewed after two thirds of it")
+	fs.DurationVar(&o.ResponseTTL, "ocsp-response-ttl", o.ResponseTTL, "How long a signed OCSP response is cached and reused, it is also the interval between its thisUpdate and nextUpdate")
+	fs.DurationVar(&o.ResyncPeriod, "ocsp-resync-period", o.ResyncPeriod, "How often every replica reloads the inventory answering OCSP requests, a revocation recorded by another replica or by the revoke command is reflected after at most this period and --ocsp-response-ttl")
+}
+
+// Validate checks the options, leaderElect tells whether more than one replica may be running.
+func (o *OCSPOptions) Validate(inventory *InventoryOptions, leaderElect bool) []error {
+	if !o.Enabled {
+		return nil
+	}
+	var allErrs []error
+	if len(inventory.Backend) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--ocsp requires --inventory-backend"))
+	}
+	// every replica answers OCSP requests but only the leader writes the file of its own replica
+	if inventory.Backend == InventoryBackendFile && leaderElect {
+		allErrs = append(allErrs, fmt.Errorf("--ocsp with the file inventory backend requires a single replica and --leader-elect=false, use the configmap or secret backend to run more than one replica"))
+	}
+	if o.ResponderCertificateDuration <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--ocsp-responder-certificate-duration must be greater than zero"))
+	}
+	if o.ResponseTTL <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--ocsp-response-ttl must be greater than zero"))
+	}
+	if o.ResyncPeriod <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--ocsp-resync-period must be greater than zero"))
+	}
+	return allErrs
+}
@@ -2,29 +2,161 @@ package options
 
 import (
 	"fmt"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/component-base/cli/flag"
+	componentbaseconfig "k8s.io/component-base/config"
+	componentbaseoptions "k8s.io/component-base/config/options"
 )
 
 type CertificateControllerOptions struct {
-	SigningCertFile string
-	SigningKeyFile  string
-	KubeConfig      string
+	SigningCertFile      string
+	SigningKeyFile       string
+	SigningPKCS11        PKCS11Options
+	SigningKMS           KMSOptions
+	SigningBackend       string
+	SigningSecret        string
+	SignersConfigFile    string
+	SigningPolicyFile    string
+	KubeConfig           string
+	BindAddress          string
+	WorkerTimeout        time.Duration
+	ClusterDomain        string
+	SANResolutionTimeout time.Duration
+
+	PublishClusterTrustBundle bool
+	CABundleConfigMapName     string
+	CABundleNamespaceSelector string
+	CRLDistributionPoints     []string
+	OCSPServers               []string
+	IssuingCertificateURLs    []string
+
+	LeaderElection componentbaseconfig.LeaderElectionConfiguration
+	Approver       ApproverOptions
+	Inventory      InventoryOptions
+	CRL            CRLOptions
+	OCSP           OCSPOptions
+
+	// Signers are the signers served by the controller, populated by Complete.
+	Signers []SignerConfiguration
+
+	signingPolicy *SigningPolicyFile
 }
 
 func NewCertificateControllerOptions() (*CertificateControllerOptions, error) {
-	return &CertificateControllerOptions{}, nil
+	return &CertificateControllerOptions{
+		BindAddress:   ":8080",
+		WorkerTimeout: 5 * time.Minute,
+		ClusterDomain: "cluster.local",
+		// a Service or Pod created along with the request usually exists within seconds
+		SANResolutionTimeout: 15 * time.Minute,
+		SigningPKCS11:        NewPKCS11Options(),
+		SigningKMS:           NewKMSOptions(),
+		Approver:             NewApproverOptions(),
+		Inventory:            NewInventoryOptions(),
+		CRL:                  NewCRLOptions(),
+		OCSP:                 NewOCSPOptions(),
+		LeaderElection: componentbaseconfig.LeaderElectionConfiguration{
+			LeaderElect:       true,
+			LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:       metav1.Duration{Duration: 2 * time.Second},
+			ResourceLock:      resourcelock.LeasesResourceLock,
+			ResourceName:      "certificate-controller",
+			ResourceNamespace: "kube-system",
+		},
+	}, nil
 }
 
 func (o *CertificateControllerOptions) Complete() error {
+	if len(o.SignersConfigFile) > 0 {
+		signers, err := loadSignersConfiguration(o.SignersConfigFile)
+		if err != nil {
+			return err
+		}
+		o.Signers = signers
+	} else if len(o.SigningCertFile) > 0 || len(o.SigningKeyFile) > 0 || len(o.SigningPKCS11.Module) > 0 || len(o.SigningKMS.Endpoint) > 0 || len(o.SigningSecret) > 0 || len(o.SigningBackend) > 0 {
+		signer := appServingSigner(o.SigningCertFile, o.SigningKeyFile)
+		signer.Backend = o.SigningBackend
+		signer.PKCS11 = o.SigningPKCS11.Configuration()
+		signer.KMS = o.SigningKMS.Configuration()
+		signer.Secret = o.SigningSecret
+		if len(o.CABundleConfigMapName) > 0 {
+			signer.CABundleConfigMap = &CABundleConfigMapConfiguration{
+				Name:              o.CABundleConfigMapName,
+				NamespaceSelector: o.CABundleNamespaceSelector,
+			}
+		}
+		signer.CRLDistributionPoints = o.CRLDistributionPoints
+		signer.OCSPServers = o.OCSPServers
+		signer.IssuingCertificateURLs = o.IssuingCertificateURLs
+		o.Signers = []SignerConfiguration{signer}
+	}
+	if len(o.SigningPolicyFile) > 0 {
+		policy, err := loadSigningPolicyFile(o.SigningPolicyFile)
+		if err != nil {
+			return err
+		}
+		o.signingPolicy = policy
+		for _, p := range policy.Policies {
+			for i := range o.Signers {
+				if o.Signers[i].Name == p.SignerName {
+					o.Signers[i].SigningPolicy = p.SigningPolicy
+				}
+			}
+		}
+	}
+	for i := range o.Signers {
+		setSigningPolicyDefaults(&o.Signers[i].SigningPolicy)
+	}
 	return nil
 }
 
 func (o *CertificateControllerOptions) Validate() error {
 	var allErrs []error
-	if len(o.SigningCertFile) == 0 && len(o.SigningKeyFile) == 0 {
-		allErrs = append(allErrs, fmt.Errorf("missing filename for serving cert"))
+	switch {
+	case len(o.SignersConfigFile) > 0:
+		if len(o.SigningCertFile) > 0 || len(o.SigningKeyFile) > 0 || len(o.SigningPKCS11.Module) > 0 || len(o.SigningKMS.Endpoint) > 0 || len(o.SigningSecret) > 0 || len(o.SigningBackend) > 0 {
+			allErrs = append(allErrs, fmt.Errorf("--signers-config-file is mutually exclusive with --signing-cert-file, --signing-key-file, --signing-pkcs11-module, --signing-kms-endpoint, --signing-secret and --signing-backend"))
+		}
+		if len(o.CABundleConfigMapName) > 0 || len(o.CABundleNamespaceSelector) > 0 {
+			allErrs = append(allErrs, fmt.Errorf("--ca-bundle-configmap-name and --ca-bundle-namespace-selector are not supported with --signers-config-file, set caBundleConfigMap of the signers instead"))
+		}
+		if len(o.CRLDistributionPoints) > 0 || len(o.OCSPServers) > 0 || len(o.IssuingCertificateURLs) > 0 {
+			allErrs = append(allErrs, fmt.Errorf("--crl-distribution-points, --ocsp-servers and --issuing-certificate-urls are not supported with --signers-config-file, set crlDistributionPoints, ocspServers and issuingCertificateURLs of the signers instead"))
+		}
+	case len(o.SigningSecret) > 0:
+		if len(o.SigningCertFile) > 0 || len(o.SigningKeyFile) > 0 || len(o.SigningPKCS11.Module) > 0 || len(o.SigningKMS.Endpoint) > 0 {
+			allErrs = append(allErrs, fmt.Errorf("--signing-secret is mutually exclusive with --signing-cert-file, --signing-key-file, --signing-pkcs11-module and --signing-kms-endpoint"))
+		}
+	case len(o.SigningBackend) > 0 && o.SigningBackend != SignerBackendLocal && o.SigningBackend != SignerBackendPKCS11 && o.SigningBackend != SignerBackendKMS:
+		// the fake and unknown backends are validated with the signers
+	case len(o.SigningCertFile) == 0 || (len(o.SigningKeyFile) == 0 && len(o.SigningPKCS11.Module) == 0 && len(o.SigningKMS.Endpoint) == 0):
+		allErrs = append(allErrs, fmt.Errorf("--signing-cert-file and --signing-key-file, --signing-pkcs11-module or --signing-kms-endpoint, --signing-secret, or --signers-config-file are required"))
+	}
+	if o.signingPolicy != nil {
+		allErrs = append(allErrs, validateSigningPolicyFile(o.SigningPolicyFile, o.signingPolicy, o.Signers)...)
+	}
+	if len(o.SignersConfigFile) > 0 || len(o.Signers) > 0 {
+		allErrs = append(allErrs, validateSigners(o.Signers)...)
+	}
+	if o.WorkerTimeout <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--worker-timeout must be greater than zero"))
+	}
+	if o.SANResolutionTimeout <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--subjectaltname-resolution-timeout must be greater than zero"))
+	}
+	allErrs = append(allErrs, o.Approver.Validate(o.Signers)...)
+	allErrs = append(allErrs, o.Inventory.Validate()...)
+	allErrs = append(allErrs, o.CRL.Validate(&o.Inventory)...)
+	allErrs = append(allErrs, o.OCSP.Validate(&o.Inventory, o.LeaderElection.LeaderElect)...)
+	if o.LeaderElection.LeaderElect {
+		allErrs = append(allErrs, validateLeaderElection(&o.LeaderElection)...)
 	}
 	return utilerrors.NewAggregate(allErrs)
 }
@@ -33,9 +165,65 @@ func (o *CertificateControllerOptions) Flags() flag.NamedFlagSets {
 	fss := flag.NamedFlagSets{}
 	pflag := fss.FlagSet("global")
 
-	pflag.StringVar(&o.SigningCertFile, "signing-cert-file", o.SigningCertFile, "Filename containing a PEM-encoded X509 CA certificate used to issue certificates for the cms.io/app-serving")
+	pflag.StringVar(&o.SigningCertFile, "signing-cert-file", o.SigningCertFile, "Filename containing a PEM-encoded X509 CA certificate used to issue certificates for the cms.io/app-serving, optionally followed by its chain up to the root when it is an intermediate CA")
 	pflag.StringVar(&o.SigningKeyFile, "signing-key-file", o.SigningKeyFile, "Filename containing a PEM-encoded RSA or ECDSA private key used to sign certificates for the cms.io/app-serving")
+	pflag.StringVar(&o.SigningSecret, "signing-secret", o.SigningSecret, "The <namespace>/<name> of a kubernetes.io/tls Secret holding the CA certificate and key used to sign certificates for the cms.io/app-serving under tls.crt and tls.key. The signer is reloaded when the Secret changes. Mutually exclusive with --signing-cert-file and --signing-key-file")
+	pflag.StringVar(&o.SigningBackend, "signing-backend", o.SigningBackend, "The backend issuing the certificates of cms.io/app-serving: local, pkcs11, kms, or fake to sign with a throwaway CA generated at startup for development and tests. Defaults to the backend matching the signing key")
+	pflag.StringVar(&o.SignersConfigFile, "signers-config-file", o.SignersConfigFile, "Filename of a YAML file configuring several signers, each with its own signer name, CA certificate and key, allowed usages and certificate lifetime. Mutually exclusive with --signing-cert-file and --signing-key-file")
+	pflag.StringVar(&o.SigningPolicyFile, "signing-policy-file", o.SigningPolicyFile, "Filename of a YAML or JSON SigningPolicy ("+SigningPolicyAPIVersion+") defining per signer the allowed and required usages, certificate lifetimes, backdate and short-lived threshold. It replaces the policy of the signers it names")
 	pflag.StringVar(&o.KubeConfig, "kubeconfig", o.KubeConfig, "path to the kubeconfig file to use for apiserver proxy")
+	pflag.StringVar(&o.BindAddress, "bind-address", o.BindAddress, "The address the http server serving /metrics, /healthz, /readyz and /livez listens on")
+	pflag.StringVar(&o.ClusterDomain, "cluster-domain", o.ClusterDomain, "The cluster domain accepted as suffix of service DNS subjectAltNames by signers that authorize subjectAltNames")
+	pflag.DurationVar(&o.SANResolutionTimeout, "subjectaltname-resolution-timeout", o.SANResolutionTimeout, "How long after its creation a certificate signing request whose subjectAltNames name a Service, Pod or Service IP that does not exist yet is retried, by signers that authorize subjectAltNames. It fails once this timeout passed")
+	pflag.BoolVar(&o.PublishClusterTrustBundle, "publish-cluster-trust-bundle", o.PublishClusterTrustBundle, "Maintain a certificates.k8s.io/v1alpha1 ClusterTrustBundle named <signer name with / replaced by :>:ca per signer, containing its CA certificates. Requires the ClusterTrustBundle API to be enabled")
+	pflag.StringVar(&o.CABundleConfigMapName, "ca-bundle-configmap-name", o.CABundleConfigMapName, "Name of a ConfigMap, e.g. cms-app-serving-ca.crt, holding the CA bundle of cms.io/app-serving under the key ca.crt that is kept in every namespace matching --ca-bundle-namespace-selector")
+	pflag.StringVar(&o.CABundleNamespaceSelector, "ca-bundle-namespace-selector", o.CABundleNamespaceSelector, "Label selector of the namespaces receiving --ca-bundle-configmap-name. An empty selector matches every namespace")
+	pflag.StringSliceVar(&o.CRLDistributionPoints, "crl-distribution-points", o.CRLDistributionPoints, "URLs added to the CRL distribution points extension of the certificates issued for cms.io/app-serving, e.g. http://certificate-controller.kube-system/crl/cms.io/app-serving. The SHA-256 fingerprint of the issuing CA is appended to the path of http and https URLs")
+	pflag.StringSliceVar(&o.OCSPServers, "ocsp-servers", o.OCSPServers, "OCSP URLs added to the authority information access extension of the certificates issued for cms.io/app-serving, e.g. http://certificate-controller.kube-system/ocsp")
+	pflag.StringSliceVar(&o.IssuingCertificateURLs, "issuing-certificate-urls", o.IssuingCertificateURLs, "caIssuers URLs added to the authority information access extension of the certificates issued for cms.io/app-serving, e.g. http://certificate-controller.kube-system/ca/cms.io/app-serving. The SHA-256 fingerprint of the issuing CA is appended to the path of http and https URLs")
+	pflag.DurationVar(&o.WorkerTimeout, "worker-timeout", o.WorkerTimeout, "The maximum time a worker may spend on a single certificate signing request before /livez reports failure")
+
+	componentbaseoptions.BindLeaderElectionFlags(&o.LeaderElection, fss.FlagSet("leader election"))
+	o.SigningPKCS11.AddFlags(fss.FlagSet("pkcs11"))
+	o.SigningKMS.AddFlags(fss.FlagSet("kms"))
+	o.Approver.AddFlags(fss.FlagSet("approver"))
+	o.Inventory.AddFlags(fss.FlagSet("inventory"))
+	o.CRL.AddFlags(fss.FlagSet("revocation"))
+	o.OCSP.AddFlags(fss.FlagSet("revocation"))
 
 	return fss
 }
+
+// ClientConfig returns the rest config built from --kubeconfig, or the in-cluster config if it is not set.
+func (o *CertificateControllerOptions) ClientConfig() (*rest.Config, error) {
+	if o.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", o.KubeConfig)
+}
+
+func validateLeaderElection(le *componentbaseconfig.LeaderElectionConfiguration) []error {
+	var allErrs []error
+	if le.LeaseDuration.Duration <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-lease-duration must be greater than zero"))
+	}
+	if le.RenewDeadline.Duration <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-renew-deadline must be greater than zero"))
+	}
+	if le.RetryPeriod.Duration <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-retry-period must be greater than zero"))
+	}
+	if le.LeaseDuration.Duration <= le.RenewDeadline.Duration {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-lease-duration must be greater than --leader-elect-renew-deadline"))
+	}
+	if le.RenewDeadline.Duration <= le.RetryPeriod.Duration {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-renew-deadline must be greater than --leader-elect-retry-period"))
+	}
+	if len(le.ResourceName) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-resource-name is required"))
+	}
+	if len(le.ResourceNamespace) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--leader-elect-resource-namespace is required"))
+	}
+	return allErrs
+}
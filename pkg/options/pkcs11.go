@@ -0,0 +1,97 @@
+package options
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// PKCS11Configuration locates a CA private key kept in a PKCS#11 token, e.g. an HSM, instead of a key file.
+type PKCS11Configuration struct {
+	// Module is the path of the PKCS#11 library of the token, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	Module string `json:"module"`
+	// TokenLabel selects the token by its label. Exactly one of TokenLabel and SlotNumber is set.
+	TokenLabel string `json:"tokenLabel,omitempty"`
+	// SlotNumber selects the token by the slot containing it.
+	SlotNumber *int `json:"slotNumber,omitempty"`
+	// KeyLabel is the CKA_LABEL of the private key. At least one of KeyLabel and KeyID is set.
+	KeyLabel string `json:"keyLabel,omitempty"`
+	// KeyID is the hexadecimal CKA_ID of the private key.
+	KeyID string `json:"keyID,omitempty"`
+	// PINFile is the file holding the user PIN of the token, surrounding whitespace is ignored.
+	PINFile string `json:"pinFile"`
+	// MaxSessions bounds the sessions opened on the token and shared by the concurrent workers, 1024 by default.
+	MaxSessions int `json:"maxSessions,omitempty"`
+}
+
+func validatePKCS11(field string, c *PKCS11Configuration) []error {
+	var allErrs []error
+	if len(c.Module) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.module is required", field))
+	}
+	if (len(c.TokenLabel) == 0) == (c.SlotNumber == nil) {
+		allErrs = append(allErrs, fmt.Errorf("%s: exactly one of tokenLabel and slotNumber is required", field))
+	}
+	if len(c.KeyLabel) == 0 && len(c.KeyID) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s: at least one of keyLabel and keyID is required", field))
+	}
+	if _, err := hex.DecodeString(c.KeyID); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("%s.keyID: %q is not hexadecimal", field, c.KeyID))
+	}
+	if len(c.PINFile) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.pinFile is required", field))
+	}
+	// the session pool requires at least two sessions, zero is the default
+	if c.MaxSessions < 0 || c.MaxSessions == 1 {
+		allErrs = append(allErrs, fmt.Errorf("%s.maxSessions must be at least 2", field))
+	}
+	return allErrs
+}
+
+// PKCS11Options locate the key of cms.io/app-serving in a PKCS#11 token instead of --signing-key-file.
+type PKCS11Options struct {
+	Module      string
+	TokenLabel  string
+	Slot        int
+	KeyLabel    string
+	KeyID       string
+	PINFile     string
+	MaxSessions int
+}
+
+func NewPKCS11Options() PKCS11Options {
+	return PKCS11Options{
+		Slot: -1,
+	}
+}
+
+func (o *PKCS11Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Module, "signing-pkcs11-module", o.Module, "Path of the PKCS#11 library of the token holding the private key of --signing-cert-file, e.g. /usr/lib/softhsm/libsofthsm2.so. Mutually exclusive with --signing-key-file")
+	fs.StringVar(&o.TokenLabel, "signing-pkcs11-token-label", o.TokenLabel, "Label of the PKCS#11 token holding the private key. Mutually exclusive with --signing-pkcs11-slot")
+	fs.IntVar(&o.Slot, "signing-pkcs11-slot", o.Slot, "Number of the slot containing the PKCS#11 token holding the private key, -1 selects the token by --signing-pkcs11-token-label")
+	fs.StringVar(&o.KeyLabel, "signing-pkcs11-key-label", o.KeyLabel, "CKA_LABEL of the private key in the PKCS#11 token")
+	fs.StringVar(&o.KeyID, "signing-pkcs11-key-id", o.KeyID, "Hexadecimal CKA_ID of the private key in the PKCS#11 token")
+	fs.StringVar(&o.PINFile, "signing-pkcs11-pin-file", o.PINFile, "File holding the user PIN of the PKCS#11 token")
+	fs.IntVar(&o.MaxSessions, "signing-pkcs11-max-sessions", o.MaxSessions, "Maximum number of sessions opened on the PKCS#11 token and shared by the workers, 0 means 1024")
+}
+
+// Configuration returns the key location set by the flags, nil if --signing-pkcs11-module is not set.
+func (o *PKCS11Options) Configuration() *PKCS11Configuration {
+	if len(o.Module) == 0 {
+		return nil
+	}
+	c := &PKCS11Configuration{
+		Module:      o.Module,
+		TokenLabel:  o.TokenLabel,
+		KeyLabel:    o.KeyLabel,
+		KeyID:       o.KeyID,
+		PINFile:     o.PINFile,
+		MaxSessions: o.MaxSessions,
+	}
+	if o.Slot >= 0 {
+		slot := o.Slot
+		c.SlotNumber = &slot
+	}
+	return c
+}
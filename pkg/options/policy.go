@@ -0,0 +1,165 @@
+package options
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// SigningPolicyAPIVersion is the only apiVersion of --signing-policy-file understood by the controller.
+	SigningPolicyAPIVersion = "signing.cms.io/v1alpha1"
+	// SigningPolicyKind is the kind of --signing-policy-file.
+	SigningPolicyKind = "SigningPolicy"
+)
+
+// SigningPolicyFile is the content of --signing-policy-file. It replaces the policy of the signers it names.
+type SigningPolicyFile struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Policies []NamedSigningPolicy `json:"policies"`
+}
+
+// NamedSigningPolicy is the policy of the signer called SignerName.
+type NamedSigningPolicy struct {
+	SignerName string `json:"signerName"`
+
+	SigningPolicy `json:",inline"`
+}
+
+// SigningPolicy constrains the certificates issued by a signer.
+type SigningPolicy struct {
+	// AllowedUsages are the key usages a certificate signing request may ask for.
+	AllowedUsages []capi.KeyUsage `json:"allowedUsages"`
+	// RequiredUsages are the key usages every certificate signing request must ask for.
+	RequiredUsages []capi.KeyUsage `json:"requiredUsages,omitempty"`
+	// DefaultDuration is the lifetime of a certificate whose request has no spec.expirationSeconds.
+	// Defaults to MaxDuration.
+	DefaultDuration metav1.Duration `json:"defaultDuration,omitempty"`
+	// MaxDuration is the maximum lifetime of an issued certificate. Defaults to one year.
+	MaxDuration metav1.Duration `json:"maxDuration,omitempty"`
+	// MinDuration is the lower bound applied to spec.expirationSeconds. Defaults to 10 minutes.
+	MinDuration metav1.Duration `json:"minDuration,omitempty"`
+	// Backdate is subtracted from NotBefore to tolerate clock skew. Defaults to 5 minutes.
+	Backdate *metav1.Duration `json:"backdate,omitempty"`
+	// ShortDuration is the lifetime below which NotAfter is not backdated. Defaults to 8 hours.
+	ShortDuration *metav1.Duration `json:"shortDuration,omitempty"`
+	// AuthorizeSubjectAltNames only allows a service account to request DNS subjectAltNames of the
+	// form <service>.<namespace>.svc[.<cluster domain>] naming Services of its own namespace, and IP
+	// subjectAltNames of the Pods and Services of its own namespace.
+	AuthorizeSubjectAltNames bool `json:"authorizeSubjectAltNames,omitempty"`
+}
+
+// appServingPolicy is the policy of the cms.io/app-serving signer when no policy file names it.
+func appServingPolicy() SigningPolicy {
+	return SigningPolicy{
+		AllowedUsages: []capi.KeyUsage{
+			capi.UsageDigitalSignature,
+			capi.UsageKeyEncipherment,
+			capi.UsageServerAuth,
+		},
+		RequiredUsages: []capi.KeyUsage{capi.UsageServerAuth},
+	}
+}
+
+func loadSigningPolicyFile(filename string) (*SigningPolicyFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	policy := &SigningPolicyFile{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("unable to decode signing policy file %q: %v", filename, err)
+	}
+	return policy, nil
+}
+
+// setSigningPolicyDefaults fills the fields left empty.
+func setSigningPolicyDefaults(p *SigningPolicy) {
+	if p.MaxDuration.Duration == 0 {
+		p.MaxDuration.Duration = 365 * 24 * time.Hour
+	}
+	if p.DefaultDuration.Duration == 0 {
+		p.DefaultDuration.Duration = p.MaxDuration.Duration
+	}
+	if p.MinDuration.Duration == 0 {
+		p.MinDuration.Duration = 10 * time.Minute
+	}
+	if p.Backdate == nil {
+		p.Backdate = &metav1.Duration{Duration: 5 * time.Minute}
+	}
+	if p.ShortDuration == nil {
+		p.ShortDuration = &metav1.Duration{Duration: 8 * time.Hour}
+	}
+}
+
+// validateSigningPolicyFile checks the header of the policy file and that every policy names a configured signer.
+func validateSigningPolicyFile(filename string, policy *SigningPolicyFile, signers []SignerConfiguration) []error {
+	var allErrs []error
+	if policy.APIVersion != SigningPolicyAPIVersion {
+		allErrs = append(allErrs, fmt.Errorf("%s: apiVersion must be %q, got %q", filename, SigningPolicyAPIVersion, policy.APIVersion))
+	}
+	if policy.Kind != SigningPolicyKind {
+		allErrs = append(allErrs, fmt.Errorf("%s: kind must be %q, got %q", filename, SigningPolicyKind, policy.Kind))
+	}
+	configured := sets.New[string]()
+	for _, s := range signers {
+		configured.Insert(s.Name)
+	}
+	seen := sets.New[string]()
+	for i, p := range policy.Policies {
+		field := fmt.Sprintf("%s: policies[%d].signerName", filename, i)
+		switch {
+		case len(p.SignerName) == 0:
+			allErrs = append(allErrs, fmt.Errorf("%s is required", field))
+		case seen.Has(p.SignerName):
+			allErrs = append(allErrs, fmt.Errorf("%s: duplicate policy for signer %q", field, p.SignerName))
+		case !configured.Has(p.SignerName):
+			allErrs = append(allErrs, fmt.Errorf("%s: signer %q is not configured", field, p.SignerName))
+		}
+		seen.Insert(p.SignerName)
+	}
+	return allErrs
+}
+
+func validateSigningPolicy(field string, p *SigningPolicy) []error {
+	var allErrs []error
+	if len(p.AllowedUsages) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.allowedUsages is required", field))
+	}
+	for _, usage := range p.AllowedUsages {
+		if !knownUsages.Has(usage) {
+			allErrs = append(allErrs, fmt.Errorf("%s.allowedUsages: unknown key usage %q", field, usage))
+		}
+	}
+	allowed := sets.New[capi.KeyUsage](p.AllowedUsages...)
+	for _, usage := range p.RequiredUsages {
+		if !allowed.Has(usage) {
+			allErrs = append(allErrs, fmt.Errorf("%s.requiredUsages: %q is not an allowed usage", field, usage))
+		}
+	}
+	if p.MinDuration.Duration <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.minDuration must be greater than zero", field))
+	}
+	if p.MaxDuration.Duration < p.MinDuration.Duration {
+		allErrs = append(allErrs, fmt.Errorf("%s.maxDuration must not be less than minDuration", field))
+	}
+	if p.DefaultDuration.Duration < p.MinDuration.Duration || p.DefaultDuration.Duration > p.MaxDuration.Duration {
+		allErrs = append(allErrs, fmt.Errorf("%s.defaultDuration must be between minDuration and maxDuration", field))
+	}
+	if p.Backdate.Duration < 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.backdate must not be negative", field))
+	}
+	if p.Backdate.Duration >= p.MinDuration.Duration {
+		allErrs = append(allErrs, fmt.Errorf("%s.backdate must be less than minDuration", field))
+	}
+	if p.ShortDuration.Duration < 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.shortDuration must not be negative", field))
+	}
+	return allErrs
+}
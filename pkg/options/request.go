@@ -0,0 +1,163 @@
+package options
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/pki"
+	capi "k8s.io/api/certificates/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/cli/flag"
+)
+
+// minExpirationSeconds is the shortest spec.expirationSeconds accepted by the apiserver.
+const minExpirationSeconds = 600
+
+// RequestOptions configures the request subcommand, generating a key, submitting its certificate
+// signing request and waiting for the certificate.
+type RequestOptions struct {
+	KubeConfig string
+
+	Name         string
+	SignerName   string
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	IPAddresses  []string
+	Usages       []string
+	Duration     time.Duration
+
+	KeyAlgorithm string
+	KeySize      int
+
+	Approve bool
+	Timeout time.Duration
+
+	CertFile string
+	KeyFile  string
+	Secret   string
+}
+
+func NewRequestOptions() *RequestOptions {
+	return &RequestOptions{
+		SignerName:   AppServingSignerName,
+		Usages:       []string{string(capi.UsageDigitalSignature), string(capi.UsageKeyEncipherment), string(capi.UsageServerAuth)},
+		KeyAlgorithm: pki.KeyAlgorithmECDSA,
+		Timeout:      5 * time.Minute,
+		CertFile:     "tls.crt",
+		KeyFile:      "tls.key",
+	}
+}
+
+func (o *RequestOptions) Validate() error {
+	var allErrs []error
+	if len(o.Name) > 0 {
+		for _, msg := range validation.IsDNS1123Subdomain(o.Name) {
+			allErrs = append(allErrs, fmt.Errorf("--name: %q: %s", o.Name, msg))
+		}
+	}
+	allErrs = append(allErrs, validateSignerName("--signer-name", o.SignerName)...)
+	if len(o.CommonName) == 0 && len(o.DNSNames) == 0 && len(o.IPAddresses) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("at least one of --common-name, --dns-names and --ip-addresses is required"))
+	}
+	for _, name := range o.DNSNames {
+		// a wildcard is left to the policy of the signer
+		for _, msg := range validation.IsDNS1123Subdomain(strings.TrimPrefix(name, "*.")) {
+			allErrs = append(allErrs, fmt.Errorf("--dns-names: %q: %s", name, msg))
+		}
+	}
+	if _, err := parseIPs(o.IPAddresses); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("--ip-addresses: %v", err))
+	}
+	if len(o.Usages) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--usages is required"))
+	}
+	for _, usage := range o.Usages {
+		if !knownUsages.Has(capi.KeyUsage(usage)) {
+			allErrs = append(allErrs, fmt.Errorf("--usages: unknown key usage %q", usage))
+		}
+	}
+	if o.Duration != 0 && (o.Duration < minExpirationSeconds*time.Second || o.Duration/time.Second > math.MaxInt32) {
+		allErrs = append(allErrs, fmt.Errorf("--duration must be 0 or between %v and %v", minExpirationSeconds*time.Second, time.Duration(math.MaxInt32)*time.Second))
+	}
+	allErrs = append(allErrs, validateKeyAlgorithm(o.KeyAlgorithm, o.KeySize)...)
+	if o.Timeout <= 0 {
+		allErrs = append(allErrs, fmt.Errorf("--timeout must be greater than zero"))
+	}
+	if len(o.Secret) > 0 {
+		allErrs = append(allErrs, validateSecretReference("--secret", o.Secret)...)
+	}
+	return utilerrors.NewAggregate(allErrs)
+}
+
+func (o *RequestOptions) Flags() flag.NamedFlagSets {
+	fss := flag.NamedFlagSets{}
+	pflag := fss.FlagSet("global")
+	pflag.StringVar(&o.KubeConfig, "kubeconfig", o.KubeConfig, "path to the kubeconfig file used to create the CertificateSigningRequest and wait for its certificate, the in-cluster config is used if empty")
+
+	request := fss.FlagSet("request")
+	request.StringVar(&o.Name, "name", o.Name, "The name of the CertificateSigningRequest, generated from the signer name if empty")
+	request.StringVar(&o.SignerName, "signer-name", o.SignerName, "The signer the certificate is requested from")
+	request.StringVar(&o.CommonName, "common-name", o.CommonName, "The common name of the subject of the certificate")
+	request.StringSliceVar(&o.Organization, "organization", o.Organization, "The organizations of the subject of the certificate")
+	request.StringSliceVar(&o.DNSNames, "dns-names", o.DNSNames, "The DNS subjectAltNames of the certificate, e.g. web.default.svc")
+	request.StringSliceVar(&o.IPAddresses, "ip-addresses", o.IPAddresses, "The IP subjectAltNames of the certificate")
+	request.StringSliceVar(&o.Usages, "usages", o.Usages, "The key usages of the certificate")
+	request.DurationVar(&o.Duration, "duration", o.Duration, "The requested lifetime of the certificate, at least 10m and bounded by the policy of the signer. 0 selects the default duration of the signer")
+	request.StringVar(&o.KeyAlgorithm, "key-algorithm", o.KeyAlgorithm, "The algorithm of the generated key: RSA, ECDSA or Ed25519")
+	request.IntVar(&o.KeySize, "key-size", o.KeySize, "The size of the generated key, 2048 to 4096 bits for RSA and 256 or 384 for ECDSA. 0 selects 3072 for RSA and 256 for ECDSA")
+	request.BoolVar(&o.Approve, "approve", o.Approve, "Approve the CertificateSigningRequest as the requester, which must be allowed to approve the requests of the signer. Without the permission no request is created. Without --approve the command waits for another approver")
+	request.DurationVar(&o.Timeout, "timeout", o.Timeout, "How long to wait for the certificate to be issued")
+
+	output := fss.FlagSet("output")
+	output.StringVar(&o.CertFile, "cert-file", o.CertFile, "The file the PEM-encoded certificate followed by the intermediates of the CA is written to")
+	output.StringVar(&o.KeyFile, "key-file", o.KeyFile, "The file the PEM-encoded key is written to")
+	output.StringVar(&o.Secret, "secret", o.Secret, "The <namespace>/<name> of a kubernetes.io/tls Secret the certificate and the key are written to instead of files, created if it does not exist")
+
+	return fss
+}
+
+// ClientConfig returns the rest config built from --kubeconfig, or the in-cluster config if it is not set.
+func (o *RequestOptions) ClientConfig() (*rest.Config, error) {
+	if o.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", o.KubeConfig)
+}
+
+// KeySizeOrDefault returns --key-size, defaulted by --key-algorithm.
+func (o *RequestOptions) KeySizeOrDefault() int {
+	return defaultKeySize(o.KeyAlgorithm, o.KeySize)
+}
+
+// IPs returns the parsed --ip-addresses.
+func (o *RequestOptions) IPs() ([]net.IP, error) {
+	return parseIPs(o.IPAddresses)
+}
+
+// ExpirationSeconds returns --duration as the spec.expirationSeconds of a request, nil if it is not set.
+func (o *RequestOptions) ExpirationSeconds() *int32 {
+	if o.Duration == 0 {
+		return nil
+	}
+	seconds := int32(o.Duration / time.Second)
+	return &seconds
+}
+
+func parseIPs(addresses []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", address)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
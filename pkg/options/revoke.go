@@ -0,0 +1,58 @@
+package options
+
+import (
+	"fmt"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/cli/flag"
+)
+
+// RevokeOptions configures the revoke subcommand, revoking a certificate of the inventory by serial.
+type RevokeOptions struct {
+	KubeConfig string
+	Serial     string
+	Reason     string
+
+	Inventory InventoryOptions
+}
+
+func NewRevokeOptions() *RevokeOptions {
+	return &RevokeOptions{
+		Reason:    "unspecified",
+		Inventory: NewInventoryOptions(),
+	}
+}
+
+func (o *RevokeOptions) Validate() error {
+	var allErrs []error
+	if len(o.Serial) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--serial is required"))
+	}
+	if len(o.Inventory.Backend) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--inventory-backend is required"))
+	}
+	allErrs = append(allErrs, o.Inventory.Validate()...)
+	return utilerrors.NewAggregate(allErrs)
+}
+
+func (o *RevokeOptions) Flags() flag.NamedFlagSets {
+	fss := flag.NamedFlagSets{}
+	pflag := fss.FlagSet("global")
+
+	pflag.StringVar(&o.KubeConfig, "kubeconfig", o.KubeConfig, "path to the kubeconfig file to use for apiserver proxy")
+	pflag.StringVar(&o.Serial, "serial", o.Serial, "The hexadecimal serial number of the certificate to revoke, optionally separated by colons")
+	pflag.StringVar(&o.Reason, "reason", o.Reason, "The revocation reason as named by RFC 5280, e.g. keyCompromise, superseded or cessationOfOperation")
+	o.Inventory.AddFlags(fss.FlagSet("inventory"))
+
+	return fss
+}
+
+// ClientConfig returns the rest config built from --kubeconfig, or the in-cluster config if it is not set.
+func (o *RevokeOptions) ClientConfig() (*rest.Config, error) {
+	if o.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", o.KubeConfig)
+}
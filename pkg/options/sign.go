@@ -0,0 +1,146 @@
+package options
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	capi "k8s.io/api/certificates/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/cli/flag"
+)
+
+// SignOptions configures the sign subcommand, issuing a certificate without the cluster with the
+// CA and the policy of a signer of the controller.
+type SignOptions struct {
+	KubeConfig string
+	CSRFile    string
+	SignerName string
+	Usages     []string
+	Duration   time.Duration
+	OutFile    string
+
+	SkipSANAuthorization bool
+
+	SigningCertFile   string
+	SigningKeyFile    string
+	SigningPKCS11     PKCS11Options
+	SigningKMS        KMSOptions
+	SignersConfigFile string
+	SigningPolicyFile string
+
+	Inventory InventoryOptions
+
+	// Signer is the signer issuing the certificate, populated by Complete.
+	Signer SignerConfiguration
+
+	controller *CertificateControllerOptions
+}
+
+func NewSignOptions() *SignOptions {
+	return &SignOptions{
+		SignerName:    AppServingSignerName,
+		Usages:        []string{string(capi.UsageDigitalSignature), string(capi.UsageKeyEncipherment), string(capi.UsageServerAuth)},
+		SigningPKCS11: NewPKCS11Options(),
+		SigningKMS:    NewKMSOptions(),
+		Inventory:     NewInventoryOptions(),
+	}
+}
+
+// Complete loads the signers the same way the controller does and selects --signer-name.
+func (o *SignOptions) Complete() error {
+	controller, err := NewCertificateControllerOptions()
+	if err != nil {
+		return err
+	}
+	controller.SigningCertFile = o.SigningCertFile
+	controller.SigningKeyFile = o.SigningKeyFile
+	controller.SigningPKCS11 = o.SigningPKCS11
+	controller.SigningKMS = o.SigningKMS
+	controller.SignersConfigFile = o.SignersConfigFile
+	controller.SigningPolicyFile = o.SigningPolicyFile
+	controller.Inventory = o.Inventory
+	if err := controller.Complete(); err != nil {
+		return err
+	}
+	o.controller = controller
+	for _, s := range controller.Signers {
+		if s.Name == o.SignerName {
+			o.Signer = s
+		}
+	}
+	return nil
+}
+
+func (o *SignOptions) Validate() error {
+	var allErrs []error
+	if len(o.CSRFile) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--csr is required"))
+	}
+	if len(o.Usages) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--usages is required"))
+	}
+	for _, usage := range o.Usages {
+		if !knownUsages.Has(capi.KeyUsage(usage)) {
+			allErrs = append(allErrs, fmt.Errorf("--usages: unknown key usage %q", usage))
+		}
+	}
+	if o.Duration < 0 || o.Duration/time.Second > math.MaxInt32 {
+		allErrs = append(allErrs, fmt.Errorf("--duration must be between 0 and %v", time.Duration(math.MaxInt32)*time.Second))
+	}
+	// the issuance is recorded so that the certificate can be found and revoked once the cluster is back
+	if len(o.Inventory.Backend) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("--inventory-backend is required"))
+	}
+	if o.controller != nil {
+		if err := o.controller.Validate(); err != nil {
+			allErrs = append(allErrs, err)
+		} else if len(o.Signer.Name) == 0 {
+			allErrs = append(allErrs, fmt.Errorf("--signer-name: signer %q is not configured", o.SignerName))
+		} else if o.Signer.AuthorizeSubjectAltNames && !o.SkipSANAuthorization {
+			allErrs = append(allErrs, fmt.Errorf("--signer-name: signer %q authorizes subjectAltNames, which requires the cluster, --skip-subjectaltname-authorization is required", o.SignerName))
+		}
+	}
+	return utilerrors.NewAggregate(allErrs)
+}
+
+func (o *SignOptions) Flags() flag.NamedFlagSets {
+	fss := flag.NamedFlagSets{}
+	pflag := fss.FlagSet("global")
+
+	pflag.StringVar(&o.KubeConfig, "kubeconfig", o.KubeConfig, "path to the kubeconfig file to use for apiserver proxy, only used by the configmap and secret inventory backends")
+	pflag.StringVar(&o.CSRFile, "csr", o.CSRFile, "Filename containing the PEM-encoded certificate request to sign")
+	pflag.StringVar(&o.SignerName, "signer-name", o.SignerName, "The signer whose CA and policy issue the certificate")
+	pflag.StringSliceVar(&o.Usages, "usages", o.Usages, "The key usages of the certificate, checked against the policy of the signer")
+	pflag.DurationVar(&o.Duration, "duration", o.Duration, "The requested lifetime of the certificate, bounded by the policy of the signer. 0 selects the default duration of the signer")
+	pflag.StringVar(&o.OutFile, "out", o.OutFile, "The file the PEM-encoded certificate followed by the intermediates of the CA is written to, standard output if empty")
+	pflag.BoolVar(&o.SkipSANAuthorization, "skip-subjectaltname-authorization", o.SkipSANAuthorization, "Issue the certificate although its signer authorizes subjectAltNames, which cannot be checked without the cluster. The inventory record of the certificate is marked subjectAltNamesUnauthorized")
+	pflag.StringVar(&o.SigningCertFile, "signing-cert-file", o.SigningCertFile, "Filename containing the PEM-encoded X509 CA certificate of cms.io/app-serving, optionally followed by its chain, as passed to the controller")
+	pflag.StringVar(&o.SigningKeyFile, "signing-key-file", o.SigningKeyFile, "Filename containing the PEM-encoded private key of cms.io/app-serving, as passed to the controller")
+	pflag.StringVar(&o.SignersConfigFile, "signers-config-file", o.SignersConfigFile, "Filename of the signers configuration of the controller. Mutually exclusive with --signing-cert-file and --signing-key-file")
+	pflag.StringVar(&o.SigningPolicyFile, "signing-policy-file", o.SigningPolicyFile, "Filename of the SigningPolicy of the controller, if any")
+	o.SigningPKCS11.AddFlags(fss.FlagSet("pkcs11"))
+	o.SigningKMS.AddFlags(fss.FlagSet("kms"))
+	o.Inventory.AddFlags(fss.FlagSet("inventory"))
+
+	return fss
+}
+
+// ExpirationSeconds returns --duration as the spec.expirationSeconds of a request, nil if it is not set.
+func (o *SignOptions) ExpirationSeconds() *int32 {
+	if o.Duration == 0 {
+		return nil
+	}
+	seconds := int32(o.Duration / time.Second)
+	return &seconds
+}
+
+// ClientConfig returns the rest config built from --kubeconfig, or the in-cluster config if it is not set.
+func (o *SignOptions) ClientConfig() (*rest.Config, error) {
+	if o.KubeConfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", o.KubeConfig)
+}
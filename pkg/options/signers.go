@@ -0,0 +1,376 @@
+package options
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// AppServingSignerName is the signer configured by --signing-cert-file and --signing-key-file.
+const AppServingSignerName = "cms.io/app-serving"
+
+// The backends built in the controller. Other backends may be registered with signer.RegisterBackend.
+const (
+	// SignerBackendLocal signs with a CA whose key is read from a file or a Secret.
+	SignerBackendLocal = "local"
+	// SignerBackendPKCS11 signs with a CA whose key is held in a PKCS#11 token.
+	SignerBackendPKCS11 = "pkcs11"
+	// SignerBackendKMS signs with a CA whose key is held by a key service.
+	SignerBackendKMS = "kms"
+	// SignerBackendFake signs with a throwaway CA generated at startup, for development and tests only.
+	SignerBackendFake = "fake"
+)
+
+// signerBackends are the names of the backends registered by signer.RegisterBackend, the backend of
+// every signer must be one of them.
+var signerBackends = struct {
+	sync.RWMutex
+	names sets.Set[string]
+}{names: sets.New[string]()}
+
+// RegisterSignerBackend makes name valid as the backend of a signer. It is called by
+// signer.RegisterBackend along with the registration of the factory of the backend.
+func RegisterSignerBackend(name string) {
+	signerBackends.Lock()
+	defer signerBackends.Unlock()
+	signerBackends.names.Insert(name)
+}
+
+// SignerBackends returns the sorted names of the registered backends.
+func SignerBackends() []string {
+	signerBackends.RLock()
+	defer signerBackends.RUnlock()
+	return sets.List(signerBackends.names)
+}
+
+func isSignerBackend(name string) bool {
+	signerBackends.RLock()
+	defer signerBackends.RUnlock()
+	return signerBackends.names.Has(name)
+}
+
+// SignersConfiguration is the content of --signers-config-file.
+type SignersConfiguration struct {
+	Signers []SignerConfiguration `json:"signers"`
+}
+
+// SignerConfiguration configures one signer served by the controller.
+type SignerConfiguration struct {
+	// Name is the spec.signerName of the certificate signing requests handled by this signer.
+	Name string `json:"name"`
+	// Backend selects the implementation issuing the certificates, one of local, pkcs11, kms and
+	// fake or a backend registered by a build of the controller. Defaults to the backend matching
+	// the key of the active CA.
+	Backend string `json:"backend,omitempty"`
+	// CertFile is the PEM-encoded CA certificate used to issue certificates. An intermediate CA is
+	// followed by its chain, each certificate being issued by the next one, optionally up to the root.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the PEM-encoded private key of the CA certificate.
+	KeyFile string `json:"keyFile,omitempty"`
+	// PKCS11 locates the private key of the CA certificate in a PKCS#11 token. Mutually exclusive with KeyFile.
+	PKCS11 *PKCS11Configuration `json:"pkcs11,omitempty"`
+	// KMS locates the private key of the CA certificate in a key service. Mutually exclusive with KeyFile and PKCS11.
+	KMS *KMSConfiguration `json:"kms,omitempty"`
+	// Secret is the <namespace>/<name> of a kubernetes.io/tls Secret holding the CA certificate under
+	// tls.crt and its private key under tls.key, reloaded when it changes. Mutually exclusive with
+	// CertFile, KeyFile, PKCS11 and KMS.
+	Secret string `json:"secret,omitempty"`
+	// CAs is the ordered set of CAs of the signer, from the newest to the oldest, used to rotate
+	// the CA without downtime. Mutually exclusive with CertFile, KeyFile, PKCS11, KMS and Secret.
+	CAs []CAConfiguration `json:"cas,omitempty"`
+
+	// CABundleConfigMap distributes the CA bundle of the signer into namespaces, if set.
+	CABundleConfigMap *CABundleConfigMapConfiguration `json:"caBundleConfigMap,omitempty"`
+
+	// CRLDistributionPoints are the URLs of the CRL distribution points extension of the issued
+	// certificates. The SHA-256 fingerprint of the issuing CA is appended to the path of http and
+	// https URLs, where the controller serves the list of that CA.
+	CRLDistributionPoints []string `json:"crlDistributionPoints,omitempty"`
+	// OCSPServers are the OCSP URLs of the authority information access extension of the issued certificates.
+	OCSPServers []string `json:"ocspServers,omitempty"`
+	// IssuingCertificateURLs are the caIssuers URLs of the authority information access extension
+	// of the issued certificates, where clients download the CA certificate. The SHA-256
+	// fingerprint of the issuing CA is appended to the path of http and https URLs.
+	IssuingCertificateURLs []string `json:"issuingCertificateURLs,omitempty"`
+
+	// SigningPolicy is replaced as a whole by the policy of --signing-policy-file naming this signer, if any.
+	SigningPolicy `json:",inline"`
+}
+
+// CAConfiguration is one CA of a signer. The CAs listed before the active one are staged: they are
+// published in the trust bundle so clients trust them before they are used. The CAs listed after
+// the active one are previous CAs: they stay in the trust bundle until they expire, and with them
+// every certificate they issued.
+type CAConfiguration struct {
+	// CertFile is the PEM-encoded CA certificate, optionally followed by its chain.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the PEM-encoded private key of the CA certificate, only required for the active CA.
+	KeyFile string `json:"keyFile,omitempty"`
+	// PKCS11 locates the private key in a PKCS#11 token instead. Mutually exclusive with KeyFile.
+	PKCS11 *PKCS11Configuration `json:"pkcs11,omitempty"`
+	// KMS locates the private key in a key service instead. Mutually exclusive with KeyFile and PKCS11.
+	KMS *KMSConfiguration `json:"kms,omitempty"`
+	// Secret is the <namespace>/<name> of a Secret holding the CA certificate and, for the active CA,
+	// its key. Mutually exclusive with CertFile, KeyFile, PKCS11 and KMS.
+	Secret string `json:"secret,omitempty"`
+	// Active marks the CA issuing new certificates. Exactly one CA is active, a single CA is active by default.
+	Active bool `json:"active,omitempty"`
+}
+
+// CertificateAuthorities returns the CAs of the signer, CertFile, KeyFile, PKCS11, KMS and Secret being a single active CA.
+func (s *SignerConfiguration) CertificateAuthorities() []CAConfiguration {
+	if len(s.CAs) > 0 {
+		return s.CAs
+	}
+	return []CAConfiguration{{CertFile: s.CertFile, KeyFile: s.KeyFile, PKCS11: s.PKCS11, KMS: s.KMS, Secret: s.Secret, Active: true}}
+}
+
+// BackendName returns Backend, defaulting to pkcs11 or kms when the key of the active CA is held
+// in a PKCS#11 token or by a key service, and to local otherwise.
+func (s *SignerConfiguration) BackendName() string {
+	if len(s.Backend) > 0 {
+		return s.Backend
+	}
+	if i := s.ActiveCA(); i >= 0 {
+		switch ca := s.CertificateAuthorities()[i]; {
+		case ca.PKCS11 != nil:
+			return SignerBackendPKCS11
+		case ca.KMS != nil:
+			return SignerBackendKMS
+		}
+	}
+	return SignerBackendLocal
+}
+
+// ActiveCA returns the index of the CA issuing new certificates in CertificateAuthorities.
+func (s *SignerConfiguration) ActiveCA() int {
+	cas := s.CertificateAuthorities()
+	if len(cas) == 1 {
+		return 0
+	}
+	for i, ca := range cas {
+		if ca.Active {
+			return i
+		}
+	}
+	return -1
+}
+
+// CABundleConfigMapConfiguration configures the ConfigMap holding the CA bundle of a signer under the key ca.crt.
+type CABundleConfigMapConfiguration struct {
+	// Name of the ConfigMap, e.g. cms-app-serving-ca.crt.
+	Name string `json:"name"`
+	// NamespaceSelector is a label selector choosing the namespaces the ConfigMap is written to.
+	// An empty selector matches every namespace.
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+}
+
+// appServingSigner returns the signer configured by --signing-cert-file and --signing-key-file.
+func appServingSigner(certFile, keyFile string) SignerConfiguration {
+	return SignerConfiguration{
+		Name:     AppServingSignerName,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+
+		SigningPolicy: appServingPolicy(),
+	}
+}
+
+func loadSignersConfiguration(filename string) ([]SignerConfiguration, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := &SignersConfiguration{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("unable to decode signers config file %q: %v", filename, err)
+	}
+	return config.Signers, nil
+}
+
+func validateSigners(signers []SignerConfiguration) []error {
+	var allErrs []error
+	if len(signers) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("at least one signer must be configured"))
+	}
+	names := map[string]bool{}
+	// the CA bundle ConfigMaps of two signers selecting the same namespace would overwrite each other
+	caBundleNames := map[string]bool{}
+	for i, s := range signers {
+		field := fmt.Sprintf("signers[%d]", i)
+		if names[s.Name] {
+			allErrs = append(allErrs, fmt.Errorf("%s.name: duplicate signer name %q", field, s.Name))
+		}
+		names[s.Name] = true
+		allErrs = append(allErrs, validateSignerName(field+".name", s.Name)...)
+		allErrs = append(allErrs, validateBackend(field, &signers[i])...)
+		if cm := s.CABundleConfigMap; cm != nil {
+			if caBundleNames[cm.Name] {
+				allErrs = append(allErrs, fmt.Errorf("%s.caBundleConfigMap.name: %q is already the CA bundle ConfigMap of another signer", field, cm.Name))
+			}
+			caBundleNames[cm.Name] = true
+			for _, msg := range validation.IsDNS1123Subdomain(cm.Name) {
+				allErrs = append(allErrs, fmt.Errorf("%s.caBundleConfigMap.name: %q: %s", field, cm.Name, msg))
+			}
+			if _, err := labels.Parse(cm.NamespaceSelector); err != nil {
+				allErrs = append(allErrs, fmt.Errorf("%s.caBundleConfigMap.namespaceSelector: %v", field, err))
+			}
+		}
+		allErrs = append(allErrs, validateURLs(field+".crlDistributionPoints", s.CRLDistributionPoints, "http", "https", "ldap")...)
+		allErrs = append(allErrs, validateURLs(field+".ocspServers", s.OCSPServers, "http", "https")...)
+		allErrs = append(allErrs, validateURLs(field+".issuingCertificateURLs", s.IssuingCertificateURLs, "http", "https", "ldap")...)
+		allErrs = append(allErrs, validateSigningPolicy(field, &signers[i].SigningPolicy)...)
+	}
+	return allErrs
+}
+
+// validateURLs checks urls are absolute URLs with one of the given schemes.
+func validateURLs(field string, urls []string, schemes ...string) []error {
+	var allErrs []error
+	for i, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			allErrs = append(allErrs, fmt.Errorf("%s[%d]: %v", field, i, err))
+			continue
+		}
+		if !sets.New(schemes...).Has(u.Scheme) || len(u.Host) == 0 {
+			allErrs = append(allErrs, fmt.Errorf("%s[%d]: %q must be an absolute URL with one of the schemes %s", field, i, raw, strings.Join(schemes, ", ")))
+		}
+	}
+	return allErrs
+}
+
+// validateBackend checks the backend is registered and, for the built-in backends, the CAs match
+// it. The other backends validate their configuration when the signer is created.
+func validateBackend(field string, s *SignerConfiguration) []error {
+	if name := s.BackendName(); !isSignerBackend(name) {
+		return []error{fmt.Errorf("%s.backend: unknown backend %q, the registered backends are %s", field, name, strings.Join(SignerBackends(), ", "))}
+	}
+	switch s.Backend {
+	case "", SignerBackendLocal, SignerBackendPKCS11, SignerBackendKMS:
+		allErrs := validateCAs(field, s)
+		if len(s.Backend) == 0 || len(allErrs) > 0 {
+			return allErrs
+		}
+		defaulted := *s
+		defaulted.Backend = ""
+		if expected := defaulted.BackendName(); expected != s.Backend {
+			allErrs = append(allErrs, fmt.Errorf("%s.backend: %q does not match the key of the active CA, which requires %q", field, s.Backend, expected))
+		}
+		return allErrs
+	case SignerBackendFake:
+		if len(s.CertFile) > 0 || len(s.KeyFile) > 0 || s.PKCS11 != nil || s.KMS != nil || len(s.Secret) > 0 || len(s.CAs) > 0 {
+			return []error{fmt.Errorf("%s: backend %q generates its CA and is mutually exclusive with certFile, keyFile, pkcs11, kms, secret and cas", field, s.Backend)}
+		}
+	}
+	return nil
+}
+
+func validateCAs(field string, s *SignerConfiguration) []error {
+	if len(s.CAs) == 0 {
+		return validateCA(field, s.CertificateAuthorities()[0], true)
+	}
+
+	var allErrs []error
+	if len(s.CertFile) > 0 || len(s.KeyFile) > 0 || s.PKCS11 != nil || s.KMS != nil || len(s.Secret) > 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s: cas is mutually exclusive with certFile, keyFile, pkcs11, kms and secret", field))
+	}
+	active := 0
+	for i, ca := range s.CAs {
+		allErrs = append(allErrs, validateCA(fmt.Sprintf("%s.cas[%d]", field, i), ca, false)...)
+		if ca.Active {
+			active++
+		}
+	}
+	switch {
+	case active > 1:
+		allErrs = append(allErrs, fmt.Errorf("%s.cas: only one CA may be active, found %d", field, active))
+	case active == 0 && len(s.CAs) > 1:
+		allErrs = append(allErrs, fmt.Errorf("%s.cas: one CA must be active", field))
+	default:
+		// the key of a Secret is checked when it is loaded
+		if i := s.ActiveCA(); len(s.CAs[i].KeyFile) == 0 && s.CAs[i].PKCS11 == nil && s.CAs[i].KMS == nil && len(s.CAs[i].Secret) == 0 {
+			allErrs = append(allErrs, fmt.Errorf("%s.cas[%d].keyFile, %s.cas[%d].pkcs11 or %s.cas[%d].kms is required for the active CA", field, i, field, i, field, i))
+		}
+	}
+	return allErrs
+}
+
+// validateCA checks the CA is read either from files or from a Secret. requireKey is set when
+// the CA must have a key file, a PKCS#11 key or a key service.
+func validateCA(field string, ca CAConfiguration, requireKey bool) []error {
+	var allErrs []error
+	if len(ca.Secret) > 0 {
+		if len(ca.CertFile) > 0 || len(ca.KeyFile) > 0 || ca.PKCS11 != nil || ca.KMS != nil {
+			allErrs = append(allErrs, fmt.Errorf("%s: secret is mutually exclusive with certFile, keyFile, pkcs11 and kms", field))
+		}
+		return append(allErrs, validateSecretReference(field+".secret", ca.Secret)...)
+	}
+	if len(ca.CertFile) == 0 {
+		allErrs = append(allErrs, fmt.Errorf("%s.certFile or %s.secret is required", field, field))
+	}
+	keys := 0
+	for _, set := range []bool{len(ca.KeyFile) > 0, ca.PKCS11 != nil, ca.KMS != nil} {
+		if set {
+			keys++
+		}
+	}
+	switch {
+	case keys > 1:
+		allErrs = append(allErrs, fmt.Errorf("%s: keyFile, pkcs11 and kms are mutually exclusive", field))
+	case ca.PKCS11 != nil:
+		allErrs = append(allErrs, validatePKCS11(field+".pkcs11", ca.PKCS11)...)
+	case ca.KMS != nil:
+		allErrs = append(allErrs, validateKMS(field+".kms", ca.KMS)...)
+	case keys == 0 && requireKey:
+		allErrs = append(allErrs, fmt.Errorf("%s.keyFile, %s.pkcs11 or %s.kms is required", field, field, field))
+	}
+	return allErrs
+}
+
+// validateSecretReference checks ref is of the form <namespace>/<name>.
+func validateSecretReference(field, ref string) []error {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found {
+		return []error{fmt.Errorf("%s: %q must be of the form <namespace>/<name>", field, ref)}
+	}
+	var allErrs []error
+	for _, msg := range validation.IsDNS1123Label(namespace) {
+		allErrs = append(allErrs, fmt.Errorf("%s: namespace %q: %s", field, namespace, msg))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(name) {
+		allErrs = append(allErrs, fmt.Errorf("%s: name %q: %s", field, name, msg))
+	}
+	return allErrs
+}
+
+// validateSignerName checks the signer name is a qualified name of the form <domain>/<path>.
+func validateSignerName(field, name string) []error {
+	domain, path, found := strings.Cut(name, "/")
+	if !found || len(path) == 0 {
+		return []error{fmt.Errorf("%s: %q must be of the form <domain>/<path>", field, name)}
+	}
+	var allErrs []error
+	for _, msg := range validation.IsDNS1123Subdomain(domain) {
+		allErrs = append(allErrs, fmt.Errorf("%s: %q: %s", field, name, msg))
+	}
+	return allErrs
+}
+
+var knownUsages = sets.New[capi.KeyUsage](
+	capi.UsageSigning, capi.UsageDigitalSignature, capi.UsageContentCommitment,
+	capi.UsageKeyEncipherment, capi.UsageKeyAgreement, capi.UsageDataEncipherment,
+	capi.UsageCertSign, capi.UsageCRLSign, capi.UsageEncipherOnly, capi.UsageDecipherOnly,
+	capi.UsageAny, capi.UsageServerAuth, capi.UsageClientAuth, capi.UsageCodeSigning,
+	capi.UsageEmailProtection, capi.UsageSMIME, capi.UsageIPsecEndSystem, capi.UsageIPsecTunnel,
+	capi.UsageIPsecUser, capi.UsageTimestamping, capi.UsageOCSPSigning, capi.UsageMicrosoftSGC,
+	capi.UsageNetscapeSGC,
+)
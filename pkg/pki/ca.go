@@ -0,0 +1,94 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"time"
+)
+
+var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// The extensions of a CA certificate requested by the certificate signing request of an intermediate CA.
+var caExtensions = map[string]bool{
+	asn1.ObjectIdentifier{2, 5, 29, 15}.String(): true, // key usage
+	asn1.ObjectIdentifier{2, 5, 29, 19}.String(): true, // basic constraints
+	asn1.ObjectIdentifier{2, 5, 29, 30}.String(): true, // name constraints
+}
+
+// CATemplate describes a CA certificate.
+type CATemplate struct {
+	Subject pkix.Name
+	// Duration is the validity of a self-signed CA, the validity of an intermediate CA is chosen by its issuer.
+	Duration time.Duration
+	// MaxPathLen is the number of intermediate CAs allowed below the CA, -1 for no limit.
+	MaxPathLen int
+
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+	PermittedIPRanges   []*net.IPNet
+	ExcludedIPRanges    []*net.IPNet
+}
+
+func (t *CATemplate) certificate(now time.Time) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               t.Subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(t.Duration),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            t.MaxPathLen,
+		MaxPathLenZero:        t.MaxPathLen == 0,
+		// RFC 5280 requires name constraints to be critical
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         t.PermittedDNSDomains,
+		ExcludedDNSDomains:          t.ExcludedDNSDomains,
+		PermittedIPRanges:           t.PermittedIPRanges,
+		ExcludedIPRanges:            t.ExcludedIPRanges,
+	}, nil
+}
+
+// NewSelfSignedCA returns a root CA certificate of key described by t.
+func NewSelfSignedCA(t CATemplate, key crypto.Signer) (*x509.Certificate, error) {
+	tmpl, err := t.certificate(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// NewCACertificateRequest returns the certificate signing request of an intermediate CA of key
+// described by t, requesting its key usage, basic constraints and name constraints.
+func NewCACertificateRequest(t CATemplate, key crypto.Signer) (*x509.CertificateRequest, error) {
+	// x509 only marshals these extensions in certificates, take them from a throwaway self-signed one
+	t.Duration = time.Hour
+	ca, err := NewSelfSignedCA(t, key)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.CertificateRequest{Subject: t.Subject}
+	for _, ext := range ca.Extensions {
+		if caExtensions[ext.Id.String()] {
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(der)
+}
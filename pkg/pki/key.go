@@ -0,0 +1,53 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// The algorithms of the generated keys.
+const (
+	KeyAlgorithmRSA     = "RSA"
+	KeyAlgorithmECDSA   = "ECDSA"
+	KeyAlgorithmEd25519 = "Ed25519"
+)
+
+// GenerateKey generates a private key. size is the modulus length of an RSA key, between 2048 and
+// 4096 bits, and the curve size of an ECDSA key, 256 or 384 bits. It is ignored for Ed25519.
+func GenerateKey(algorithm string, size int) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA:
+		if size < 2048 || size > 4096 {
+			return nil, fmt.Errorf("unsupported RSA key size %d, it must be between 2048 and 4096", size)
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case KeyAlgorithmECDSA:
+		switch size {
+		case 256:
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case 384:
+			return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		}
+		return nil, fmt.Errorf("unsupported ECDSA key size %d, it must be 256 or 384", size)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+}
+
+// MarshalPrivateKeyPEM encodes key as a PEM-encoded PKCS#8 private key.
+func MarshalPrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+// HealthChecker is a named health check served by the health endpoints.
+type HealthChecker interface {
+	Name() string
+	Check(req *http.Request) error
+}
+
+type healthzCheck struct {
+	name  string
+	check func(req *http.Request) error
+}
+
+func (c healthzCheck) Name() string {
+	return c.name
+}
+
+func (c healthzCheck) Check(req *http.Request) error {
+	return c.check(req)
+}
+
+// NamedCheck returns a HealthChecker for the given name and check function.
+func NamedCheck(name string, check func(req *http.Request) error) HealthChecker {
+	return healthzCheck{name: name, check: check}
+}
+
+// pingHealthz always succeeds, it only proves the server is able to respond.
+var pingHealthz HealthChecker = NamedCheck("ping", func(_ *http.Request) error {
+	return nil
+})
+
+// InstallHealthzHandler serves a ping check followed by checks at path. The response is "ok"
+// if every check passes, otherwise it is a 500 listing the failed checks. ?verbose lists every check.
+func (s *Server) InstallHealthzHandler(path string, checks ...HealthChecker) {
+	s.Handle(path, healthzHandler(path, append([]HealthChecker{pingHealthz}, checks...)))
+}
+
+func healthzHandler(path string, checks []HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var individual bytes.Buffer
+		failed := false
+		for _, check := range checks {
+			if err := check.Check(r); err != nil {
+				klog.V(2).InfoS("Health check failed", "path", path, "check", check.Name(), "err", err)
+				fmt.Fprintf(&individual, "[-]%s failed: %v\n", check.Name(), err)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(&individual, "[+]%s ok\n", check.Name())
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			individual.WriteTo(w)
+			fmt.Fprintf(w, "%s check failed\n", path)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			individual.WriteTo(w)
+			fmt.Fprintf(w, "%s check passed\n", path)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// shutdownTimeout bounds how long in-flight requests are given to finish on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// Server is the plain HTTP server of the certificate controller. It serves endpoints
+// that must be reachable on every replica, whether or not it holds the leader lease.
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+	// raw are the handlers by path prefix bypassing mux
+	raw map[string]http.Handler
+}
+
+func NewServer(addr string) *Server {
+	return &Server{
+		addr: addr,
+		mux:  http.NewServeMux(),
+		raw:  map[string]http.Handler{},
+	}
+}
+
+// Handle registers the handler for the given pattern.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandlePrefix registers the handler for the paths starting with prefix. Unlike Handle, the path is
+// passed as sent by the client, the ServeMux would clean paths carrying encoded data such as base64
+// and redirect the client to another path.
+func (s *Server) HandlePrefix(prefix string, handler http.Handler) {
+	s.raw[prefix] = handler
+}
+
+// ServeHTTP dispatches the request to the handler of its path prefix, if any, or to the ServeMux.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.EscapedPath()
+	for prefix, handler := range s.raw {
+		if strings.HasPrefix(path, prefix) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, req)
+}
+
+// Run serves until ctx is done, then shuts the server down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		klog.InfoS("Starting http server", "address", s.addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	klog.InfoS("Shutting down http server", "address", s.addr)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,94 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	capi "k8s.io/api/certificates/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Signer issues the certificates of the certificate signing requests of one signer name.
+type Signer interface {
+	// Name returns the signer name this signer issues certificates for.
+	Name() string
+	// Run keeps the signer up to date until ctx is done, it is run on every replica.
+	Run(ctx context.Context)
+	// Check reports whether the signer is able to sign, it is part of /readyz.
+	Check(ctx context.Context) error
+
+	// Sign issues a certificate for request. It returns the DER-encoded certificate followed by the
+	// intermediates of the issuing CA, if any, or an error wrapping ErrCAExpired once the CA expired.
+	Sign(ctx context.Context, request *x509.CertificateRequest, usages []capi.KeyUsage, expirationSeconds *int32) ([][]byte, error)
+
+	// Certificate returns the certificate of the CA issuing new certificates.
+	Certificate() *x509.Certificate
+	// TrustBundle returns the PEM-encoded CA certificates verifying the certificates of the signer.
+	TrustBundle() []byte
+	// CACertificates returns the certificates of every CA of the signer, active, staged or previous,
+	// and of their chains, which clients fetch from the caIssuers URLs to build the chain.
+	CACertificates() []*x509.Certificate
+	// AddListener registers a function called whenever the CAs or the trust bundle change.
+	AddListener(listener func())
+}
+
+// ErrCAExpired is wrapped by the errors of a signer whose CA, or a certificate of its chain, expired.
+var ErrCAExpired = errors.New("signing CA expired")
+
+// RevocationSigner is a Signer with access to the keys of its CAs, which the controller uses to
+// sign certificate revocation lists and OCSP responses with the CA that issued the certificates.
+type RevocationSigner interface {
+	Signer
+
+	// FindCA returns the first CA for which match returns true along with its private key.
+	FindCA(match func(*x509.Certificate) bool) (*x509.Certificate, crypto.Signer)
+}
+
+// CAStatus is implemented by the signers whose CA expiration and rotation phase are exposed as metrics.
+type CAStatus interface {
+	// NotAfter returns the time after which the signer can no longer issue certificates.
+	NotAfter() time.Time
+	// RotationPhase reports how far a rotation of the CA has progressed.
+	RotationPhase() string
+}
+
+// Factory creates a signer from its configuration. client reads the Kubernetes objects the signer
+// depends on, it may be nil when the signer depends on none.
+type Factory func(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error)
+
+var backends = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: map[string]Factory{}}
+
+// RegisterBackend makes a backend available to the signers configured with its name. It is meant
+// to be called from the init function of the file or package implementing the backend, and panics
+// when the name is already registered.
+func RegisterBackend(name string, factory Factory) {
+	backends.Lock()
+	defer backends.Unlock()
+	if _, ok := backends.factories[name]; ok {
+		panic(fmt.Sprintf("signer backend %q is already registered", name))
+	}
+	backends.factories[name] = factory
+	options.RegisterSignerBackend(name)
+}
+
+// New creates the signer configured by config with the backend it selects.
+func New(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	name := config.BackendName()
+	backends.RLock()
+	factory, ok := backends.factories[name]
+	backends.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("signer %q: unknown backend %q, the registered backends are %s", config.Name, name, strings.Join(options.SignerBackends(), ", "))
+	}
+	return factory(config, client)
+}
@@ -0,0 +1,420 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/kmsplugin"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/klog/v2"
+)
+
+// caReloadInterval is how often the CA files are checked for changes.
+const caReloadInterval = 30 * time.Second
+
+const (
+	// RotationPhaseStable is reported when the active CA is the only CA of the signer in use.
+	RotationPhaseStable = "stable"
+	// RotationPhaseStaged is reported when a newer CA is published in the trust bundle but does not sign yet.
+	RotationPhaseStaged = "staged"
+	// RotationPhaseOverlap is reported when a previous CA is kept in the trust bundle until the certificates it issued expire.
+	RotationPhaseOverlap = "overlap"
+)
+
+// signingCA is an immutable CA certificate, its chain and its private key.
+type signingCA struct {
+	certificate *x509.Certificate
+	// privateKey is nil for staged and previous CAs configured without a key
+	privateKey crypto.Signer
+	// chain are the certificates appended to the issued certificates: the CA certificate followed by
+	// the intermediates up to, but excluding, the root. It is empty when the CA is a root.
+	chain []*x509.Certificate
+	// anchor is the last certificate of the CA cert file, published in the trust bundle
+	anchor *x509.Certificate
+	// notAfter is the earliest NotAfter of the chain, no issued certificate outlives it
+	notAfter time.Time
+}
+
+// caSet is an immutable snapshot of the CAs of a signer.
+type caSet struct {
+	// files holds the content of the CA files the set was parsed from
+	files [][]byte
+
+	active *signingCA
+	// staged are the CAs newer than the active one, newest first
+	staged []*signingCA
+	// previous are the CAs older than the active one, newest first
+	previous []*signingCA
+}
+
+// trustBundle returns the CAs that verify the certificates of the signer at now: the staged CAs so
+// clients trust them before they sign, the active CA and the previous CAs that have not expired yet.
+// Each CA is represented by the last certificate of its chain, CAs sharing a root are listed once.
+func (s *caSet) trustBundle(now time.Time) []*x509.Certificate {
+	var certs []*x509.Certificate
+	add := func(ca *signingCA) {
+		for _, cert := range certs {
+			if cert.Equal(ca.anchor) {
+				return
+			}
+		}
+		certs = append(certs, ca.anchor)
+	}
+	for _, ca := range s.staged {
+		if now.Before(ca.notAfter) {
+			add(ca)
+		}
+	}
+	add(s.active)
+	for _, ca := range s.previous {
+		if now.Before(ca.notAfter) {
+			add(ca)
+		}
+	}
+	return certs
+}
+
+// certificates returns the certificate of every CA of the set and the certificates of their chains
+// up to the root, each listed once.
+func (s *caSet) certificates() []*x509.Certificate {
+	var certs []*x509.Certificate
+	add := func(cert *x509.Certificate) {
+		for _, c := range certs {
+			if c.Equal(cert) {
+				return
+			}
+		}
+		certs = append(certs, cert)
+	}
+	for _, ca := range append(append([]*signingCA{s.active}, s.staged...), s.previous...) {
+		add(ca.certificate)
+		for _, cert := range ca.chain {
+			add(cert)
+		}
+		add(ca.anchor)
+	}
+	return certs
+}
+
+// rotationPhase returns the RotationPhase* of the set at now.
+func (s *caSet) rotationPhase(now time.Time) string {
+	for _, ca := range s.staged {
+		if now.Before(ca.notAfter) {
+			return RotationPhaseStaged
+		}
+	}
+	// certificates never outlive their chain, so a previous CA is needed until the NotAfter of its chain
+	for _, ca := range s.previous {
+		if now.Before(ca.notAfter) {
+			return RotationPhaseOverlap
+		}
+	}
+	return RotationPhaseStable
+}
+
+// caProvider loads the CAs of a signer from disk or from Secrets and swaps them atomically when they
+// change, so a rotated CA is picked up without restarting and without affecting in-flight signing.
+type caProvider struct {
+	cas    []options.CAConfiguration
+	active int
+	// secrets are the Secrets of the CAs read from a Secret, nil for the CAs read from files
+	secrets []*caSecret
+
+	current atomic.Pointer[caSet]
+
+	// reloadLock serializes the reloads triggered periodically and by Secret events
+	reloadLock sync.Mutex
+	bundleSize int
+
+	listenersLock sync.Mutex
+	listeners     []func()
+}
+
+func newCAProvider(cas []options.CAConfiguration, active int, client kubernetes.Interface) (*caProvider, error) {
+	p := &caProvider{
+		cas:     cas,
+		active:  active,
+		secrets: make([]*caSecret, len(cas)),
+	}
+	for i, ca := range cas {
+		if len(ca.Secret) == 0 {
+			continue
+		}
+		secret, err := newCASecret(client, ca.Secret)
+		if err != nil {
+			return nil, err
+		}
+		p.secrets[i] = secret
+	}
+	if _, err := p.load(); err != nil {
+		return nil, err
+	}
+	p.bundleSize = len(p.currentSet().trustBundle(time.Now()))
+	return p, nil
+}
+
+// newStaticCAProvider returns a provider of ca, which is never reloaded.
+func newStaticCAProvider(ca *signingCA) *caProvider {
+	p := &caProvider{bundleSize: 1}
+	p.current.Store(&caSet{active: ca})
+	return p
+}
+
+// currentCA returns the CA that is currently used for signing.
+func (p *caProvider) currentCA() *signingCA {
+	return p.current.Load().active
+}
+
+// currentSet returns all the CAs currently loaded.
+func (p *caProvider) currentSet() *caSet {
+	return p.current.Load()
+}
+
+// load reads the CA files and Secrets and replaces the current CAs if their content has changed. Keys
+// held in a PKCS#11 token are looked up again whenever the certificate file changes.
+func (p *caProvider) load() (bool, error) {
+	files := make([][]byte, 0, 2*len(p.cas))
+	for i, ca := range p.cas {
+		if secret := p.secrets[i]; secret != nil {
+			certPem, keyPem, err := secret.read()
+			if err != nil {
+				return false, err
+			}
+			files = append(files, certPem, keyPem)
+			continue
+		}
+		certPem, err := os.ReadFile(ca.CertFile)
+		if err != nil {
+			return false, err
+		}
+		var keyPem []byte
+		if len(ca.KeyFile) > 0 {
+			if keyPem, err = os.ReadFile(ca.KeyFile); err != nil {
+				return false, err
+			}
+		}
+		files = append(files, certPem, keyPem)
+	}
+	if set := p.current.Load(); set != nil && filesEqual(set.files, files) {
+		return false, nil
+	}
+
+	set := &caSet{files: files}
+	for i, ca := range p.cas {
+		loaded, err := parseCA(ca, files[2*i], files[2*i+1])
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case i < p.active:
+			set.staged = append(set.staged, loaded)
+		case i == p.active:
+			if loaded.privateKey == nil {
+				return false, fmt.Errorf("error reading CA cert %s: the active CA requires a key", caSource(ca))
+			}
+			set.active = loaded
+		default:
+			set.previous = append(set.previous, loaded)
+		}
+	}
+	p.current.Store(set)
+	return true, nil
+}
+
+func parseCA(ca options.CAConfiguration, certPem, keyPem []byte) (*signingCA, error) {
+	certs, err := cert.ParseCertsPEM(certPem)
+	if err != nil {
+		return nil, err
+	}
+	loaded, err := parseChain(certs)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA cert %s: %v", caSource(ca), err)
+	}
+	if ca.PKCS11 != nil {
+		priv, err := pkcs11Key(ca.PKCS11)
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeysEqual(certs[0].PublicKey, priv.Public()) {
+			return nil, fmt.Errorf("PKCS#11 key %s does not match CA cert %s", describePKCS11Key(ca.PKCS11), caSource(ca))
+		}
+		loaded.privateKey = priv
+		return loaded, nil
+	}
+	if ca.KMS != nil {
+		key, err := kmsplugin.NewKey(context.Background(), ca.KMS.Endpoint, ca.KMS.Timeout.Duration)
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeysEqual(certs[0].PublicKey, key.Public()) {
+			return nil, fmt.Errorf("key of key service %s does not match CA cert %s", key, caSource(ca))
+		}
+		loaded.privateKey = key
+		return loaded, nil
+	}
+	if keyPem == nil {
+		return loaded, nil
+	}
+	key, err := keyutil.ParsePrivateKeyPEM(keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA key %s: %v", caKeySource(ca), err)
+	}
+	priv, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("error reading CA key %s: key did not implement crypto.Signer", caKeySource(ca))
+	}
+	// the files of a rotated secret may be observed half-updated, never pair a certificate with the wrong key
+	if !publicKeysEqual(certs[0].PublicKey, priv.Public()) {
+		return nil, fmt.Errorf("CA key %s does not match CA cert %s", caKeySource(ca), caSource(ca))
+	}
+	loaded.privateKey = priv
+	return loaded, nil
+}
+
+// caSource identifies where the certificate of a CA is read from in errors.
+func caSource(ca options.CAConfiguration) string {
+	if len(ca.Secret) > 0 {
+		return fmt.Sprintf("secret %q key %s", ca.Secret, corev1.TLSCertKey)
+	}
+	return fmt.Sprintf("file %q", ca.CertFile)
+}
+
+// caKeySource identifies where the private key of a CA is read from in errors.
+func caKeySource(ca options.CAConfiguration) string {
+	if len(ca.Secret) > 0 {
+		return fmt.Sprintf("secret %q key %s", ca.Secret, corev1.TLSPrivateKeyKey)
+	}
+	return fmt.Sprintf("file %q", ca.KeyFile)
+}
+
+// describePKCS11Key identifies a PKCS#11 key in errors.
+func describePKCS11Key(c *options.PKCS11Configuration) string {
+	token := fmt.Sprintf("token %q", c.TokenLabel)
+	if c.SlotNumber != nil {
+		token = fmt.Sprintf("slot %d", *c.SlotNumber)
+	}
+	return fmt.Sprintf("(label %q, id %q) in %s of module %q", c.KeyLabel, c.KeyID, token, c.Module)
+}
+
+// parseChain checks that every certificate is issued by the next one. The first certificate is the
+// CA issuing certificates, the last one is either a root or an intermediate whose root is kept offline.
+func parseChain(certs []*x509.Certificate) (*signingCA, error) {
+	ca := &signingCA{
+		certificate: certs[0],
+		anchor:      certs[len(certs)-1],
+		notAfter:    certs[0].NotAfter,
+	}
+	for i, cert := range certs {
+		if !cert.IsCA {
+			return nil, fmt.Errorf("certificate %d %q is not a CA", i, cert.Subject)
+		}
+		if cert.NotAfter.Before(ca.notAfter) {
+			ca.notAfter = cert.NotAfter
+		}
+		if i == len(certs)-1 {
+			if !isSelfSigned(cert) {
+				ca.chain = append(ca.chain, cert)
+			}
+			break
+		}
+		if err := cert.CheckSignatureFrom(certs[i+1]); err != nil {
+			return nil, fmt.Errorf("certificate %d %q is not issued by certificate %d %q: %v", i, cert.Subject, i+1, certs[i+1].Subject, err)
+		}
+		ca.chain = append(ca.chain, cert)
+	}
+	return ca, nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// AddListener registers a function called whenever the CAs or the trust bundle change.
+func (p *caProvider) AddListener(listener func()) {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+	p.listeners = append(p.listeners, listener)
+}
+
+func (p *caProvider) notifyListeners() {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+	for _, listener := range p.listeners {
+		listener()
+	}
+}
+
+// Run periodically reloads the CA files, and the CA Secrets whenever they change, until ctx is done.
+// Listeners are also notified when an expired staged or previous CA drops out of the trust bundle.
+func (p *caProvider) Run(ctx context.Context) {
+	for _, secret := range p.secrets {
+		if secret == nil {
+			continue
+		}
+		secret.Start(ctx, p.reload)
+		if !cache.WaitForNamedCacheSync("ca secret "+secret.String(), ctx.Done(), secret.synced) {
+			return
+		}
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		p.reload()
+	}, caReloadInterval)
+}
+
+func (p *caProvider) reload() {
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+
+	changed, err := p.load()
+	if err != nil {
+		klog.ErrorS(err, "Unable to reload signing CA, keep using the current one", "cas", p.cas)
+		return
+	}
+	set := p.currentSet()
+	now := time.Now()
+	if changed {
+		ca := set.active
+		klog.InfoS("Reloaded signing CA", "subject", ca.certificate.Subject.String(), "notAfter", ca.notAfter, "chainLength", len(ca.chain), "rotationPhase", set.rotationPhase(now))
+	}
+	// expiry only removes CAs, a bundle of the same size is unchanged
+	if size := len(set.trustBundle(now)); changed || size != p.bundleSize {
+		p.bundleSize = size
+		p.notifyListeners()
+	}
+}
+
+func filesEqual(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	type equaler interface {
+		Equal(crypto.PublicKey) bool
+	}
+	if k, ok := a.(equaler); ok {
+		return k.Equal(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
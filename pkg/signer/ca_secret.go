@@ -0,0 +1,108 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// caSecret watches the Secret holding a CA certificate under tls.crt and its private key under tls.key.
+type caSecret struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	factory informers.SharedInformerFactory
+	lister  corelisters.SecretLister
+	synced  cache.InformerSynced
+}
+
+// newCASecret watches the Secret ref, of the form <namespace>/<name>.
+func newCASecret(client kubernetes.Interface, ref string) (*caSecret, error) {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found {
+		return nil, fmt.Errorf("invalid secret %q, expected <namespace>/<name>", ref)
+	}
+	if client == nil {
+		return nil, fmt.Errorf("secret %q: a kubernetes client is required to read the CA from a secret", ref)
+	}
+	// the controller only needs this Secret, not every Secret of the namespace
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+	)
+	secrets := factory.Core().V1().Secrets()
+	return &caSecret{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		factory:   factory,
+		lister:    secrets.Lister(),
+		synced:    secrets.Informer().HasSynced,
+	}, nil
+}
+
+// String returns the <namespace>/<name> of the Secret.
+func (s *caSecret) String() string {
+	return s.namespace + "/" + s.name
+}
+
+// read returns the PEM-encoded certificate and key of the Secret. It reads from the informer cache
+// once synced and from the API server before, so that the signer can be created before the informer starts.
+func (s *caSecret) read() ([]byte, []byte, error) {
+	var secret *corev1.Secret
+	var err error
+	if s.synced() {
+		secret, err = s.lister.Secrets(s.namespace).Get(s.name)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		secret, err = s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read CA secret %s: %v", s, err)
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		return nil, nil, fmt.Errorf("CA secret %s is of type %s, not %s", s, secret.Type, corev1.SecretTypeTLS)
+	}
+	certPem, keyPem := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(certPem) == 0 {
+		return nil, nil, fmt.Errorf("CA secret %s has no %s", s, corev1.TLSCertKey)
+	}
+	// a staged or previous CA may be published without its key, the type requires an empty tls.key then
+	if len(keyPem) == 0 {
+		keyPem = nil
+	}
+	return certPem, keyPem, nil
+}
+
+// Start starts the informer and calls onChange whenever the Secret is added, updated or deleted.
+func (s *caSecret) Start(ctx context.Context, onChange func()) {
+	informer := s.factory.Core().V1().Secrets().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			onChange()
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if old.(*corev1.Secret).ResourceVersion != new.(*corev1.Secret).ResourceVersion {
+				onChange()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.InfoS("CA secret deleted, keep using the current CA", "secret", s.String())
+		},
+	})
+	s.factory.Start(ctx.Done())
+}
@@ -0,0 +1,74 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+func init() {
+	RegisterBackend(options.SignerBackendFake, newFakeSigner)
+}
+
+// newFakeSigner creates the signer of the fake backend, which depends on no Kubernetes object.
+func newFakeSigner(config options.SignerConfiguration, _ kubernetes.Interface) (Signer, error) {
+	cs, err := NewFakeSigner(config)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// fakeCADuration is the lifetime of the CA generated by the fake backend.
+const fakeCADuration = 10 * 365 * 24 * time.Hour
+
+// NewFakeSigner creates the signer of the fake backend. It issues certificates with the same policy
+// as the other backends from a self-signed CA generated in memory, which changes on every restart.
+// It is meant for development and tests, where no CA needs to be provisioned.
+func NewFakeSigner(config options.SignerConfiguration) (*CustomerSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("%s fake CA", config.Name)},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(fakeCADuration),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
+	}
+	ca, err := parseChain([]*x509.Certificate{certificate})
+	if err != nil {
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
+	}
+	ca.privateKey = key
+
+	klog.InfoS("Generated a throwaway CA for the fake backend, the certificates it issues are not trusted after a restart", "signer", config.Name, "subject", certificate.Subject.String())
+	return &CustomerSigner{
+		config:     config,
+		caProvider: newStaticCAProvider(ca),
+	}, nil
+}
@@ -0,0 +1,15 @@
+package signer
+
+import (
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"k8s.io/client-go/kubernetes"
+)
+
+func init() {
+	RegisterBackend(options.SignerBackendKMS, newKMSSigner)
+}
+
+// newKMSSigner creates the signer of the kms backend, whose active CA key is held by a key service.
+func newKMSSigner(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	return newCASigner(config, client)
+}
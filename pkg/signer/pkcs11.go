@@ -0,0 +1,87 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"k8s.io/client-go/kubernetes"
+)
+
+func init() {
+	RegisterBackend(options.SignerBackendPKCS11, newPKCS11Signer)
+}
+
+// newPKCS11Signer creates the signer of the pkcs11 backend, whose active CA key is held in a PKCS#11 token.
+func newPKCS11Signer(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	return newCASigner(config, client)
+}
+
+// pkcs11Tokens are the tokens logged in by module, token and PIN file. A token is logged in once
+// and its pool of sessions is shared by every CA whose key it holds and every concurrent worker.
+var pkcs11Tokens = struct {
+	sync.Mutex
+	contexts map[string]*crypto11.Context
+}{contexts: map[string]*crypto11.Context{}}
+
+// pkcs11Key returns the private key located by config, signing in the token through a pooled session.
+func pkcs11Key(config *options.PKCS11Configuration) (crypto.Signer, error) {
+	ctx, err := pkcs11Token(config)
+	if err != nil {
+		return nil, err
+	}
+	var id, label []byte
+	if len(config.KeyID) > 0 {
+		if id, err = hex.DecodeString(config.KeyID); err != nil {
+			return nil, fmt.Errorf("invalid PKCS#11 key id %q: %v", config.KeyID, err)
+		}
+	}
+	if len(config.KeyLabel) > 0 {
+		label = []byte(config.KeyLabel)
+	}
+	key, err := ctx.FindKeyPair(id, label)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find PKCS#11 key %s: %v", describePKCS11Key(config), err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("PKCS#11 key %s not found", describePKCS11Key(config))
+	}
+	return key, nil
+}
+
+func pkcs11Token(config *options.PKCS11Configuration) (*crypto11.Context, error) {
+	slot := -1
+	if config.SlotNumber != nil {
+		slot = *config.SlotNumber
+	}
+	token := fmt.Sprintf("%s|%s|%d|%s", config.Module, config.TokenLabel, slot, config.PINFile)
+
+	pkcs11Tokens.Lock()
+	defer pkcs11Tokens.Unlock()
+	if ctx, ok := pkcs11Tokens.contexts[token]; ok {
+		return ctx, nil
+	}
+	pin, err := os.ReadFile(config.PINFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:        config.Module,
+		TokenLabel:  config.TokenLabel,
+		SlotNumber:  config.SlotNumber,
+		Pin:         strings.TrimSpace(string(pin)),
+		MaxSessions: config.MaxSessions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in PKCS#11 token of module %q: %v", config.Module, err)
+	}
+	pkcs11Tokens.contexts[token] = ctx
+	return ctx, nil
+}
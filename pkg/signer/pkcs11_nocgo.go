@@ -0,0 +1,25 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+	"k8s.io/client-go/kubernetes"
+)
+
+func init() {
+	RegisterBackend(options.SignerBackendPKCS11, newPKCS11Signer)
+}
+
+// newPKCS11Signer fails, the PKCS#11 libraries are loaded through cgo.
+func newPKCS11Signer(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	return nil, fmt.Errorf("signer %q: backend %q is not supported by this build, it requires cgo", config.Name, options.SignerBackendPKCS11)
+}
+
+// pkcs11Key fails, the PKCS#11 libraries are loaded through cgo.
+func pkcs11Key(config *options.PKCS11Configuration) (crypto.Signer, error) {
+	return nil, fmt.Errorf("PKCS#11 key %s: PKCS#11 is not supported by this build, it requires cgo", describePKCS11Key(config))
+}
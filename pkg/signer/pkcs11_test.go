@@ -0,0 +1,229 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericpuwang/certificate-controller/pkg/options"
+)
+
+// softHSMModules are the usual install locations of the SoftHSM PKCS#11 library, SOFTHSM2_MODULE
+// takes precedence.
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// softHSM is the token shared by the tests. The PKCS#11 module is initialized once per process and
+// only reads its configuration then, so every test uses the same token.
+var softHSM struct {
+	once   sync.Once
+	dir    string
+	config *options.PKCS11Configuration
+	skip   string
+	err    error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if len(softHSM.dir) > 0 {
+		os.RemoveAll(softHSM.dir)
+	}
+	os.Exit(code)
+}
+
+// softHSMToken returns the configuration of a P-256 key generated in a SoftHSM token initialized in
+// a temporary directory. The test is skipped when SoftHSM is not installed.
+func softHSMToken(t *testing.T) *options.PKCS11Configuration {
+	t.Helper()
+	softHSM.once.Do(func() {
+		softHSM.config, softHSM.skip, softHSM.err = newSoftHSMToken()
+	})
+	if len(softHSM.skip) > 0 {
+		t.Skip(softHSM.skip)
+	}
+	if softHSM.err != nil {
+		t.Fatal(softHSM.err)
+	}
+	return softHSM.config
+}
+
+func newSoftHSMToken() (*options.PKCS11Configuration, string, error) {
+	util, err := exec.LookPath("softhsm2-util")
+	if err != nil {
+		return nil, "softhsm2-util not found", nil
+	}
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, path := range softHSMModules {
+		if len(module) > 0 {
+			break
+		}
+		if _, err := os.Stat(path); err == nil {
+			module = path
+		}
+	}
+	if len(module) == 0 {
+		return nil, "SoftHSM PKCS#11 module not found, set SOFTHSM2_MODULE", nil
+	}
+
+	if softHSM.dir, err = os.MkdirTemp("", "softhsm"); err != nil {
+		return nil, "", err
+	}
+	tokens := filepath.Join(softHSM.dir, "tokens")
+	if err := os.Mkdir(tokens, 0700); err != nil {
+		return nil, "", err
+	}
+	conf := filepath.Join(softHSM.dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokens)), 0600); err != nil {
+		return nil, "", err
+	}
+	if err := os.Setenv("SOFTHSM2_CONF", conf); err != nil {
+		return nil, "", err
+	}
+	if out, err := exec.Command(util, "--init-token", "--free", "--label", "certificate-controller", "--pin", "1234", "--so-pin", "5678").CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("unable to initialize SoftHSM token: %v: %s", err, out)
+	}
+	pinFile := filepath.Join(softHSM.dir, "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0600); err != nil {
+		return nil, "", err
+	}
+
+	config := &options.PKCS11Configuration{
+		Module:     module,
+		TokenLabel: "certificate-controller",
+		KeyLabel:   "ca",
+		KeyID:      "01",
+		PINFile:    pinFile,
+		// fewer sessions than concurrent workers, the workers wait for a session of the pool
+		MaxSessions: 2,
+	}
+	ctx, err := pkcs11Token(config)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := ctx.GenerateECDSAKeyPairWithLabel([]byte{1}, []byte("ca"), elliptic.P256()); err != nil {
+		return nil, "", fmt.Errorf("unable to generate key in SoftHSM token: %v", err)
+	}
+	return config, "", nil
+}
+
+func TestPKCS11Key(t *testing.T) {
+	config := softHSMToken(t)
+
+	key, err := pkcs11Key(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("unexpected public key %T", key.Public())
+	}
+
+	digest := sha256.Sum256([]byte("certificate-controller"))
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(public, digest[:], signature) {
+		t.Fatal("signature does not verify with the public key of the token")
+	}
+
+	// the key signs certificates like the key of a CA read from a file
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pkcs11 CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, public, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.CheckSignatureFrom(ca); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pkcs11Key(&options.PKCS11Configuration{
+		Module:     config.Module,
+		TokenLabel: config.TokenLabel,
+		KeyLabel:   "missing",
+		PINFile:    config.PINFile,
+	}); err == nil {
+		t.Fatal("expected an error for a missing key")
+	}
+}
+
+func TestPKCS11KeyConcurrent(t *testing.T) {
+	config := softHSMToken(t)
+
+	const workers, signatures = 16, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			// every worker looks the key up, the token is only logged in once
+			key, err := pkcs11Key(config)
+			if err != nil {
+				errs <- err
+				return
+			}
+			public := key.Public().(*ecdsa.PublicKey)
+			for j := 0; j < signatures; j++ {
+				digest := sha256.Sum256([]byte(fmt.Sprintf("worker %d signature %d", worker, j)))
+				signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !ecdsa.VerifyASN1(public, digest[:], signature) {
+					errs <- fmt.Errorf("worker %d: signature %d does not verify", worker, j)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	first, err := pkcs11Token(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pkcs11Token(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("the token was logged in twice")
+	}
+}
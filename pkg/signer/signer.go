@@ -1,14 +1,19 @@
 package signer
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"math/big"
-	"os"
+	"net/url"
 	"time"
 
+	"github.com/ericpuwang/certificate-controller/pkg/kmsplugin"
 	"github.com/ericpuwang/certificate-controller/pkg/options"
 	capi "k8s.io/api/certificates/v1"
 	_ "k8s.io/apimachinery"
@@ -16,21 +21,26 @@ import (
 	"k8s.io/client-go/kubernetes"
 	certificateslisters "k8s.io/client-go/listers/certificates/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/util/cert"
-	"k8s.io/client-go/util/keyutil"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
-const defaultCSRDuration = time.Hour * 24 * 365
-
 var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
 
+var (
+	_ RevocationSigner = &CustomerSigner{}
+	_ CAStatus         = &CustomerSigner{}
+)
+
+func init() {
+	RegisterBackend(options.SignerBackendLocal, newLocalSigner)
+}
+
+// CustomerSigner issues certificates from the CAs of its configuration, it implements the local,
+// pkcs11, kms and fake backends.
 type CustomerSigner struct {
-	keyPem      []byte
-	certPem     []byte
-	certificate *x509.Certificate
-	privateKey  crypto.Signer
+	config     options.SignerConfiguration
+	caProvider *caProvider
 
 	kubeClient  kubernetes.Interface
 	csrInformer cache.SharedIndexInformer
@@ -39,42 +49,96 @@ type CustomerSigner struct {
 	queue       workqueue.RateLimitingInterface
 }
 
-func NewCustomerSigner(opts *options.CertificateControllerOptions) (*CustomerSigner, error) {
-	keyPem, err := os.ReadFile(opts.SigningKeyFile)
-	if err != nil {
-		return nil, err
-	}
-	certPem, err := os.ReadFile(opts.SigningCertFile)
+// NewCustomerSigner returns the signer configured by config. client reads the CAs kept in Secrets, it may
+// be nil when every CA is read from files.
+func NewCustomerSigner(config options.SignerConfiguration, client kubernetes.Interface) (*CustomerSigner, error) {
+	caProvider, err := newCAProvider(config.CertificateAuthorities(), config.ActiveCA(), client)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("signer %q: %v", config.Name, err)
 	}
-	certs, err := cert.ParseCertsPEM(certPem)
-	if err != nil {
-		return nil, err
-	}
-	if len(certs) != 1 {
-		return nil, fmt.Errorf("error reading CA cert file %q: expected 1 certificate, found %d", opts.SigningCertFile, len(certs))
+
+	cs := &CustomerSigner{
+		config:     config,
+		caProvider: caProvider,
+		kubeClient: client,
 	}
-	key, err := keyutil.ParsePrivateKeyPEM(keyPem)
+
+	return cs, nil
+}
+
+// newLocalSigner creates the signer of the local backend, whose active CA key is read from a file or a Secret.
+func newLocalSigner(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	return newCASigner(config, client)
+}
+
+// newCASigner creates the CustomerSigner of a backend loading the keys of the CAs of its
+// configuration, a nil signer is never returned as a non-nil Signer.
+func newCASigner(config options.SignerConfiguration, client kubernetes.Interface) (Signer, error) {
+	cs, err := NewCustomerSigner(config, client)
 	if err != nil {
 		return nil, err
 	}
-	priv, ok := key.(crypto.Signer)
-	if !ok {
-		return nil, fmt.Errorf("error reading CA key file %q: key did not implement crypto.Signer", opts.SigningKeyFile)
-	}
+	return cs, nil
+}
 
-	cs := &CustomerSigner{
-		keyPem:      keyPem,
-		certPem:     certPem,
-		certificate: certs[0],
-		privateKey:  priv,
+// Run keeps the CAs up to date with the files on disk and the Secrets until ctx is done.
+func (cs *CustomerSigner) Run(ctx context.Context) {
+	cs.caProvider.Run(ctx)
+}
+
+// Name returns the signer name this signer issues certificates for.
+func (cs *CustomerSigner) Name() string {
+	return cs.config.Name
+}
+
+// Certificate returns the CA certificate currently used for signing.
+func (cs *CustomerSigner) Certificate() *x509.Certificate {
+	return cs.caProvider.currentCA().certificate
+}
+
+// NotAfter returns the end of validity of the CA currently used for signing, the earliest NotAfter of its chain.
+func (cs *CustomerSigner) NotAfter() time.Time {
+	return cs.caProvider.currentCA().notAfter
+}
+
+// TrustBundle returns the PEM-encoded CA certificates that verify the certificates issued by this signer.
+// During a CA rotation it holds the staged and previous CAs besides the active one.
+func (cs *CustomerSigner) TrustBundle() []byte {
+	var bundle []byte
+	for _, cert := range cs.caProvider.currentSet().trustBundle(time.Now()) {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
 	}
+	return bundle
+}
 
-	return cs, nil
+// CACertificates returns the certificates of every CA of this signer and of their chains.
+func (cs *CustomerSigner) CACertificates() []*x509.Certificate {
+	return cs.caProvider.currentSet().certificates()
 }
 
-func (cs *CustomerSigner) Sign(certificateRequest *x509.CertificateRequest, usages []capi.KeyUsage, expirationSeconds *int32) ([]byte, error) {
+// RotationPhase returns the CA rotation phase of this signer, one of the RotationPhase* constants.
+func (cs *CustomerSigner) RotationPhase() string {
+	return cs.caProvider.currentSet().rotationPhase(time.Now())
+}
+
+// AddListener registers a function called whenever the CAs or the trust bundle of this signer change.
+func (cs *CustomerSigner) AddListener(listener func()) {
+	cs.caProvider.AddListener(listener)
+}
+
+// Sign issues a certificate for certificateRequest. It returns the DER-encoded certificate followed by
+// the intermediates of the signing CA, if any.
+func (cs *CustomerSigner) Sign(ctx context.Context, certificateRequest *x509.CertificateRequest, usages []capi.KeyUsage, expirationSeconds *int32) ([][]byte, error) {
+	// pin the CA for the whole signing operation, a concurrent reload must not mix certificate and key
+	ca := cs.caProvider.currentCA()
+	if !time.Now().Before(ca.notAfter) {
+		return nil, fmt.Errorf("%w at %v", ErrCAExpired, ca.notAfter)
+	}
+	key := ca.privateKey
+	if remote, ok := key.(*kmsplugin.Key); ok {
+		key = remote.WithContext(ctx)
+	}
+
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
 		klog.ErrorS(err, "Unable to generate a serial number")
@@ -93,34 +157,87 @@ func (cs *CustomerSigner) Sign(certificateRequest *x509.CertificateRequest, usag
 	policy := PermissiveSigningPolicy{
 		TTL:      cs.duration(expirationSeconds),
 		Usages:   usages,
-		Backdate: 5 * time.Minute,
-		Short:    8 * time.Hour,
+		Backdate: cs.config.Backdate.Duration,
+		Short:    cs.config.ShortDuration.Duration,
 		Now:      time.Now,
 	}
-	if err := policy.apply(tmpl, cs.certificate.NotAfter); err != nil {
+	if err := policy.apply(tmpl, ca.notAfter); err != nil {
 		klog.ErrorS(err, "Unable to apply signing policy")
 		return nil, err
 	}
+	// the policy drops the extensions of the request, the signer adds where to check revocation and find the CA
+	tmpl.CRLDistributionPoints = caURLs(cs.config.CRLDistributionPoints, ca.certificate)
+	tmpl.OCSPServer = cs.config.OCSPServers
+	tmpl.IssuingCertificateURL = caURLs(cs.config.IssuingCertificateURLs, ca.certificate)
 
-	cert, err := x509.CreateCertificate(rand.Reader, tmpl, cs.certificate, certificateRequest.PublicKey, cs.privateKey)
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, ca.certificate, certificateRequest.PublicKey, key)
 	if err != nil {
 		klog.ErrorS(err, "Failed to sign certificate")
 		return nil, err
 	}
-	return cert, nil
+	chain := [][]byte{cert}
+	for _, intermediate := range ca.chain {
+		chain = append(chain, intermediate.Raw)
+	}
+	return chain, nil
+}
+
+// FindCA returns the first CA of this signer, the active one followed by the staged and previous ones,
+// for which match returns true along with its private key. CAs without a key are skipped.
+func (cs *CustomerSigner) FindCA(match func(*x509.Certificate) bool) (*x509.Certificate, crypto.Signer) {
+	set := cs.caProvider.currentSet()
+	cas := append([]*signingCA{set.active}, set.staged...)
+	for _, ca := range append(cas, set.previous...) {
+		if ca.privateKey != nil && match(ca.certificate) {
+			return ca.certificate, ca.privateKey
+		}
+	}
+	return nil, nil
+}
+
+// Check checks the active CA has not expired and the key service holding its key, if any, is healthy.
+func (cs *CustomerSigner) Check(ctx context.Context) error {
+	ca := cs.caProvider.currentCA()
+	if !time.Now().Before(ca.notAfter) {
+		return fmt.Errorf("%w at %v", ErrCAExpired, ca.notAfter)
+	}
+	if key, ok := ca.privateKey.(*kmsplugin.Key); ok {
+		return key.Check(ctx)
+	}
+	return nil
+}
+
+// caURLs returns urls with the SHA-256 fingerprint of ca appended to the path of the http and https
+// URLs, so that the certificates issued by every CA of a rotation point to the revocation list and
+// the certificate of the CA that issued them, which the controller serves at <url>/<fingerprint>.
+func caURLs(urls []string, ca *x509.Certificate) []string {
+	if len(urls) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(ca.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	stamped := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			raw = u.JoinPath(fingerprint).String()
+		}
+		stamped = append(stamped, raw)
+	}
+	return stamped
 }
 
 func (cs *CustomerSigner) duration(expirationSeconds *int32) time.Duration {
 	if expirationSeconds == nil {
-		return defaultCSRDuration
+		return cs.config.DefaultDuration.Duration
 	}
 
-	// honor requested duration is if it is less than the default TTL
-	// use 10 min (2x hard coded backdate above) as a sanity check lower bound
-	const min = 10 * time.Minute
+	// honor requested duration is if it is within the configured bounds,
+	// the lower bound (10 min by default, 2x the default backdate) is a sanity check
+	max := cs.config.MaxDuration.Duration
+	min := cs.config.MinDuration.Duration
 	switch requestedDuration := time.Duration(*expirationSeconds) * time.Second; {
-	case requestedDuration > defaultCSRDuration:
-		return defaultCSRDuration
+	case requestedDuration > max:
+		return max
 	case requestedDuration < min:
 		return min
 	default: